@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PermMode is an octal permission setting from the mapping file
+type PermMode struct {
+	Perm os.FileMode
+	Set  bool
+}
+
+// UnmarshalYAML reads the mode as octal, with or without a leading 0 or 0o
+func (m *PermMode) UnmarshalYAML(value *yaml.Node) error {
+	text := strings.TrimPrefix(strings.TrimPrefix(value.Value, "0o"), "0")
+	if text == "" {
+		text = "0"
+	}
+
+	perm, err := strconv.ParseUint(text, 8, 32)
+	if value.Kind != yaml.ScalarNode || err != nil || perm > 07777 {
+		return fmt.Errorf("line %d: invalid mode %q, expected an octal value such as 0644", value.Line, value.Value)
+	}
+	*m = PermMode{Perm: toFileMode(uint32(perm)), Set: true}
+	return nil
+}
+
+// toFileMode converts unix permission bits including setuid, setgid and sticky
+func toFileMode(perm uint32) os.FileMode {
+	mode := os.FileMode(perm & 0777)
+	if perm&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if perm&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if perm&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// fileAttrs are the permissions and ownership a mapping applies to what it installs
+type fileAttrs struct {
+	fileMode PermMode
+	dirMode  PermMode
+	uid, gid int // -1 leaves the ID unchanged
+}
+
+// noAttrs leaves permissions and ownership as decided by tgzetup
+var noAttrs = fileAttrs{uid: -1, gid: -1}
+
+// mappingAttrs resolves the mode, owner and group settings of a mapping
+// 'mode' applies to files and directories unless 'file_mode' or 'dir_mode' is set
+func mappingAttrs(mapping Mapping) (fileAttrs, error) {
+	attrs := noAttrs
+
+	attrs.fileMode = mapping.Mode
+	if mapping.FileMode.Set {
+		attrs.fileMode = mapping.FileMode
+	}
+	attrs.dirMode = mapping.Mode
+	if mapping.DirMode.Set {
+		attrs.dirMode = mapping.DirMode
+	}
+
+	if mapping.Owner != "" {
+		uid, err := lookupOwner(mapping.Owner)
+		if err != nil {
+			return noAttrs, err
+		}
+		attrs.uid = uid
+	}
+	if mapping.Group != "" {
+		gid, err := lookupGroup(mapping.Group)
+		if err != nil {
+			return noAttrs, err
+		}
+		attrs.gid = gid
+	}
+
+	return attrs, nil
+}
+
+// hasDirAttrs reports whether directories need a mode or ownership change
+func (a fileAttrs) hasDirAttrs() bool {
+	return a.dirMode.Set || a.uid != -1 || a.gid != -1
+}
+
+// applyFile sets the ownership and mode of an installed file
+// The owner is set first since chown clears the setuid and setgid bits.
+func (a fileAttrs) applyFile(path string) error {
+	if err := a.applyOwner(path); err != nil {
+		return err
+	}
+	if a.fileMode.Set {
+		if err := os.Chmod(path, a.fileMode.Perm); err != nil {
+			return fmt.Errorf("failed to set mode: %w", err)
+		}
+	}
+	return nil
+}
+
+// applyDir sets the ownership and mode of an installed directory
+func (a fileAttrs) applyDir(path string) error {
+	if err := a.applyOwner(path); err != nil {
+		return err
+	}
+	if a.dirMode.Set {
+		if err := os.Chmod(path, a.dirMode.Perm); err != nil {
+			return fmt.Errorf("failed to set mode: %w", err)
+		}
+	}
+	return nil
+}
+
+// applyOwner sets the ownership of path, without following symlinks
+func (a fileAttrs) applyOwner(path string) error {
+	if a.uid == -1 && a.gid == -1 {
+		return nil
+	}
+	if err := os.Lchown(path, a.uid, a.gid); err != nil {
+		return fmt.Errorf("failed to set owner: %w", err)
+	}
+	return nil
+}
+
+// lookupOwner returns the UID of a user name or numeric ID
+func lookupOwner(owner string) (int, error) {
+	if uid, err := strconv.Atoi(owner); err == nil && uid >= 0 {
+		return uid, nil
+	}
+	u, err := user.Lookup(owner)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lookup user %s: %w", owner, err)
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// lookupGroup returns the GID of a group name or numeric ID
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil && gid >= 0 {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lookup group %s: %w", group, err)
+	}
+	return strconv.Atoi(g.Gid)
+}
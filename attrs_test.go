@@ -0,0 +1,225 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestPermModeUnmarshal(t *testing.T) {
+	tests := []struct {
+		yaml    string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{yaml: `mode: 0644`, want: 0644},
+		{yaml: `mode: "0755"`, want: 0755},
+		{yaml: `mode: 640`, want: 0640},
+		{yaml: `mode: 0o600`, want: 0600},
+		{yaml: `mode: 04755`, want: 0755 | os.ModeSetuid},
+		{yaml: `mode: 0999`, wantErr: true},
+		{yaml: `mode: 017777`, wantErr: true},
+		{yaml: `mode: rwx`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		var mapping Mapping
+		err := yaml.Unmarshal([]byte(tt.yaml), &mapping)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Unmarshal(%q) error = %v, wantErr %v", tt.yaml, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (!mapping.Mode.Set || mapping.Mode.Perm != tt.want) {
+			t.Errorf("Unmarshal(%q) = %+v, want %v", tt.yaml, mapping.Mode, tt.want)
+		}
+	}
+}
+
+func TestMappingAttrs(t *testing.T) {
+	mapping := Mapping{
+		Mode:    PermMode{Perm: 0644, Set: true},
+		DirMode: PermMode{Perm: 0755, Set: true},
+		Owner:   strconv.Itoa(os.Getuid()),
+	}
+
+	attrs, err := mappingAttrs(mapping)
+	if err != nil {
+		t.Fatalf("mappingAttrs() unexpected error: %v", err)
+	}
+	if attrs.fileMode.Perm != 0644 {
+		t.Errorf("expected file mode 0644 from 'mode', got %v", attrs.fileMode.Perm)
+	}
+	if attrs.dirMode.Perm != 0755 {
+		t.Errorf("expected dir mode 0755 from 'dir_mode', got %v", attrs.dirMode.Perm)
+	}
+	if attrs.uid != os.Getuid() || attrs.gid != -1 {
+		t.Errorf("expected owner %d and unchanged group, got %d:%d", os.Getuid(), attrs.uid, attrs.gid)
+	}
+
+	if _, err := mappingAttrs(Mapping{Owner: "no-such-user-tgzetup"}); err == nil {
+		t.Error("mappingAttrs() expected error for unknown owner, got nil")
+	}
+}
+
+func TestInstallMappingAttrs(t *testing.T) {
+	extractDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(extractDir, "tool", "conf"), 0755); err != nil {
+		t.Fatalf("failed to create source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(extractDir, "tool", "conf", "tool.conf"), []byte("conf"), 0777); err != nil {
+		t.Fatalf("failed to create source: %v", err)
+	}
+
+	target := filepath.Join(t.TempDir(), "opt", "tool")
+	mapping := Mapping{
+		From:     "tool",
+		To:       target,
+		FileMode: PermMode{Perm: 0640, Set: true},
+		DirMode:  PermMode{Perm: 0750, Set: true},
+	}
+
+	tx := newTransaction(&Receipt{}, newLinkResolver(extractDir, []Mapping{mapping}))
+	if err := installMapping(extractDir, mapping, tx); err != nil {
+		t.Fatalf("installMapping() unexpected error: %v", err)
+	}
+	if err := tx.commit(); err != nil {
+		t.Fatalf("commit() unexpected error: %v", err)
+	}
+
+	for path, want := range map[string]os.FileMode{
+		target:                        0750,
+		filepath.Join(target, "conf"): 0750,
+		filepath.Join(target, "conf", "tool.conf"): 0640,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("expected %s to be installed: %v", path, err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("%s: expected mode %v, got %v", path, want, info.Mode().Perm())
+		}
+	}
+}
+
+func TestDryRunMappingAttrs(t *testing.T) {
+	extractDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(extractDir, "tool.conf"), []byte("conf"), 0644); err != nil {
+		t.Fatalf("failed to create source: %v", err)
+	}
+
+	// Changing the owner needs root, a dry run must work without it
+	uid := os.Getuid() + 1
+	mapping := Mapping{
+		From:  "tool.conf",
+		To:    filepath.Join(t.TempDir(), "tool.conf"),
+		Mode:  PermMode{Perm: 0600, Set: true},
+		Owner: strconv.Itoa(uid),
+	}
+	tx := newDryRunTransaction(&Receipt{}, newLinkResolver(extractDir, []Mapping{mapping}), t.TempDir())
+	if err := installMapping(extractDir, mapping, tx); err != nil {
+		t.Fatalf("installMapping() unexpected error: %v", err)
+	}
+	if len(tx.staged) != 1 {
+		t.Fatalf("expected 1 staged file, got %d", len(tx.staged))
+	}
+
+	f := tx.staged[0]
+	info, err := os.Stat(f.staging)
+	if err != nil {
+		t.Fatalf("expected staged copy: %v", err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("expected staged copy to keep mode 0644, got %v", info.Mode().Perm())
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		t.Errorf("expected staged copy to keep owner %d, got %d", os.Getuid(), st.Uid)
+	}
+	if f.attrs.uid != uid || !f.attrs.fileMode.Set || f.attrs.fileMode.Perm != 0600 {
+		t.Errorf("expected planned owner %d and mode 0600, got %+v", uid, f.attrs)
+	}
+	if err := tx.printPlan(); err != nil {
+		t.Errorf("printPlan() unexpected error: %v", err)
+	}
+}
+
+func TestApplyFileKeepsSetuid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tool")
+	if err := os.WriteFile(path, []byte("tool"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	// chown clears the setuid bit, so the mode must be set afterwards
+	attrs := fileAttrs{
+		fileMode: PermMode{Perm: 0755 | os.ModeSetuid, Set: true},
+		uid:      os.Getuid(),
+		gid:      os.Getgid(),
+	}
+	if err := attrs.applyFile(path); err != nil {
+		t.Fatalf("applyFile() unexpected error: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	if info.Mode()&os.ModeSetuid == 0 || info.Mode().Perm() != 0755 {
+		t.Errorf("expected mode 04755, got %v", info.Mode())
+	}
+}
+
+func TestInstallMappingAttrsExistingDirectories(t *testing.T) {
+	extractDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(extractDir, "tool", "conf"), 0755); err != nil {
+		t.Fatalf("failed to create source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(extractDir, "tool", "conf", "tool.conf"), []byte("conf"), 0644); err != nil {
+		t.Fatalf("failed to create source: %v", err)
+	}
+
+	// Left behind by an earlier installation without dir_mode
+	parent := filepath.Join(t.TempDir(), "opt")
+	target := filepath.Join(parent, "tool")
+	if err := os.MkdirAll(filepath.Join(target, "conf"), 0755); err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+	mapping := Mapping{
+		From:    "tool",
+		To:      target,
+		DirMode: PermMode{Perm: 0750, Set: true},
+	}
+
+	install := func() *transaction {
+		tx := newTransaction(&Receipt{}, newLinkResolver(extractDir, []Mapping{mapping}))
+		if err := installMapping(extractDir, mapping, tx); err != nil {
+			t.Fatalf("installMapping() unexpected error: %v", err)
+		}
+		if err := tx.commit(); err != nil {
+			t.Fatalf("commit() unexpected error: %v", err)
+		}
+		return tx
+	}
+	checkModes := func(want map[string]os.FileMode) {
+		t.Helper()
+		for path, mode := range want {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("failed to stat %s: %v", path, err)
+			}
+			if info.Mode().Perm() != mode {
+				t.Errorf("%s: expected mode %v, got %v", path, mode, info.Mode().Perm())
+			}
+		}
+	}
+
+	// Rollback restores the previous mode of existing directories
+	install().rollback()
+	checkModes(map[string]os.FileMode{target: 0755, filepath.Join(target, "conf"): 0755})
+
+	// The directories of the mapping change, the parent above it does not
+	install().cleanup()
+	checkModes(map[string]os.FileMode{parent: 0755, target: 0750, filepath.Join(target, "conf"): 0750})
+}
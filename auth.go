@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Auth is the authentication for downloads configured in the mapping file
+// Values may reference environment variables as ${NAME} so secrets stay
+// out of the mapping file.
+type Auth struct {
+	Token    string            `yaml:"token"`
+	Username string            `yaml:"username"`
+	Password string            `yaml:"password"`
+	Headers  map[string]string `yaml:"headers"`
+	Hosts    []string          `yaml:"hosts"`
+}
+
+// validate checks that the settings can be combined
+func (a *Auth) validate() error {
+	if a.Token != "" && (a.Username != "" || a.Password != "") {
+		return fmt.Errorf("'token' and 'username'/'password' cannot be used together")
+	}
+	if a.Password != "" && a.Username == "" {
+		return fmt.Errorf("'password' requires 'username'")
+	}
+	for name := range a.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	return nil
+}
+
+// appliesTo reports whether the mapping file authentication is sent to host
+func (a *Auth) appliesTo(host string) bool {
+	return len(a.Hosts) == 0 || slices.Contains(a.Hosts, host)
+}
+
+// forArchive returns the authentication used when installing archiveURL
+// Without hosts, credentials are only sent to the host of the archive and
+// not to a checksum or signature file hosted elsewhere.
+func (a *Auth) forArchive(archiveURL string) *Auth {
+	if a == nil || len(a.Hosts) > 0 {
+		return a
+	}
+	u, err := url.Parse(archiveURL)
+	if err != nil || u.Hostname() == "" {
+		// Local archives have no host to send the credentials to
+		return nil
+	}
+	scoped := *a
+	scoped.Hosts = []string{u.Hostname()}
+	return &scoped
+}
+
+// credentials are the authentication headers sent with requests to a URL
+type credentials struct {
+	header http.Header
+	source string // where the credentials came from, never the secret itself
+}
+
+// githubHosts are the hosts GITHUB_TOKEN is sent to
+var githubHosts = []string{"github.com", "api.github.com"}
+
+// resolveCredentials determines the authentication for a request to rawURL
+// Credentials in the URL take precedence, then the mapping file, then
+// GITHUB_TOKEN for GitHub and finally ~/.netrc.
+func resolveCredentials(auth *Auth, rawURL string) (*credentials, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	host := u.Hostname()
+	creds := &credentials{header: make(http.Header)}
+
+	if auth != nil && auth.appliesTo(host) {
+		for name, value := range auth.Headers {
+			expanded, err := expandSecret(value, "header "+name)
+			if err != nil {
+				return nil, err
+			}
+			creds.header.Set(name, expanded)
+		}
+	}
+
+	switch {
+	case u.User != nil:
+		// Sent by the HTTP client itself
+		creds.source = "credentials in URL"
+	case auth != nil && auth.appliesTo(host) && auth.Token != "":
+		token, err := expandSecret(auth.Token, "auth token")
+		if err != nil {
+			return nil, err
+		}
+		creds.header.Set("Authorization", "Bearer "+token)
+		creds.source = "bearer token from mapping file"
+	case auth != nil && auth.appliesTo(host) && auth.Username != "":
+		username, err := expandSecret(auth.Username, "auth username")
+		if err != nil {
+			return nil, err
+		}
+		password, err := expandSecret(auth.Password, "auth password")
+		if err != nil {
+			return nil, err
+		}
+		setBasicAuth(creds.header, username, password)
+		creds.source = "basic auth from mapping file"
+	case os.Getenv("GITHUB_TOKEN") != "" && slices.Contains(githubHosts, host):
+		creds.header.Set("Authorization", "Bearer "+os.Getenv("GITHUB_TOKEN"))
+		creds.source = "GITHUB_TOKEN"
+	default:
+		login, password, path, err := lookupNetrc(host)
+		if err != nil {
+			return nil, err
+		}
+		if login != "" || password != "" {
+			setBasicAuth(creds.header, login, password)
+			creds.source = path
+		}
+	}
+
+	if creds.source == "" && len(creds.header) > 0 {
+		creds.source = "headers from mapping file"
+	}
+	return creds, nil
+}
+
+// setBasicAuth sets the Authorization header for basic authentication
+func setBasicAuth(header http.Header, username, password string) {
+	req := &http.Request{Header: header}
+	req.SetBasicAuth(username, password)
+}
+
+// apply adds the credentials to a request
+func (c *credentials) apply(req *http.Request) {
+	if c == nil {
+		return
+	}
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
+}
+
+// checkRedirect drops the credentials when a redirect leaves the original
+// host, e.g. from a release page to a storage service with signed URLs.
+// The HTTP client only does this for its own authentication headers.
+func (c *credentials) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if c != nil && req.URL.Host != via[0].URL.Host {
+		for name := range c.header {
+			req.Header.Del(name)
+		}
+	}
+	return nil
+}
+
+// expandSecret expands ${NAME} references to environment variables,
+// failing when one is not set rather than sending an empty secret
+func expandSecret(value, what string) (string, error) {
+	var missing []string
+	expanded := os.Expand(value, func(name string) string {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s used in %s is not set", strings.Join(missing, ", "), what)
+	}
+	return expanded, nil
+}
+
+// netrcPath returns the location of the netrc file, $NETRC or ~/.netrc
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".netrc")
+}
+
+// lookupNetrc returns the login and password for host from the netrc file
+// A missing netrc file is not an error.
+func lookupNetrc(host string) (login, password, path string, err error) {
+	path = netrcPath()
+	if path == "" {
+		return "", "", "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", "", "", nil
+		}
+		return "", "", "", fmt.Errorf("failed to read netrc file: %w", err)
+	}
+	login, password = parseNetrc(string(data), host)
+	return login, password, path, nil
+}
+
+// parseNetrc returns the login and password of the entry for host, falling
+// back to the default entry
+func parseNetrc(data, host string) (login, password string) {
+	type entry struct{ login, password string }
+	var match, fallback *entry
+	var current *entry
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Macro definitions run until the next empty line
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			value := ""
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine":
+				current = &entry{}
+				if value == host && match == nil {
+					match = current
+				}
+				i++
+			case "default":
+				current = &entry{}
+				if fallback == nil {
+					fallback = current
+				}
+			case "login":
+				if current != nil {
+					current.login = value
+				}
+				i++
+			case "password":
+				if current != nil {
+					current.password = value
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				current = nil
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+
+	if match != nil {
+		return match.login, match.password
+	}
+	if fallback != nil {
+		return fallback.login, fallback.password
+	}
+	return "", ""
+}
+
+// redactError hides secrets in the URL of a failed request
+func redactError(err error) {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = redactURL(urlErr.URL)
+	}
+}
+
+// sensitiveQueryKeys are substrings of query parameter names whose values are hidden in output
+var sensitiveQueryKeys = []string{"token", "secret", "signature", "password", "key", "credential", "auth", "sig"}
+
+// redactURL hides the password and secret looking query parameters of a URL
+// so it can be printed and recorded
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" {
+		return rawURL
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		changed := false
+		for name := range query {
+			lower := strings.ToLower(name)
+			for _, key := range sensitiveQueryKeys {
+				if strings.Contains(lower, key) {
+					query[name] = []string{"xxxxx"}
+					changed = true
+					break
+				}
+			}
+		}
+		if changed {
+			u.RawQuery = query.Encode()
+		}
+	}
+	return u.String()
+}
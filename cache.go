@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Cache stores downloaded archives by SHA-256 digest, with an index from
+// source URL to digest and the validators needed to revalidate it
+type Cache struct {
+	dir      string
+	readOnly bool // used without storing new downloads, e.g. in dry-run mode
+}
+
+// CacheEntry records which archive a URL returned
+type CacheEntry struct {
+	URL          string    `json:"url"`
+	SHA256       string    `json:"sha256"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+
+	path string // index file the entry was read from
+}
+
+// defaultCacheDir returns the directory where downloaded archives are cached
+// Root uses /var/cache/tgzetup, other users use $XDG_CACHE_HOME/tgzetup
+func defaultCacheDir() string {
+	if dir := os.Getenv("TGZETUP_CACHE_DIR"); dir != "" {
+		return dir
+	}
+
+	if os.Geteuid() == 0 {
+		return "/var/cache/tgzetup"
+	}
+
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "tgzetup")
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "tgzetup-cache")
+	}
+	return filepath.Join(homeDir, ".cache", "tgzetup")
+}
+
+// NewCache returns the cache in dir, or the default cache directory if dir is empty
+func NewCache(dir string) *Cache {
+	if dir == "" {
+		dir = defaultCacheDir()
+	}
+	return &Cache{dir: dir}
+}
+
+// blobPath returns where the archive with the given digest is stored
+func (c *Cache) blobPath(digest string) string {
+	return filepath.Join(c.dir, "archives", digest)
+}
+
+// entryPath returns where the index entry for a URL is stored
+func (c *Cache) entryPath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, "urls", hex.EncodeToString(sum[:])+".json")
+}
+
+// has reports whether the archive with the given digest is cached
+func (c *Cache) has(digest string) bool {
+	info, err := os.Stat(c.blobPath(digest))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// entry returns the index entry for a URL, or nil if the URL was never cached
+// or its archive is gone
+func (c *Cache) entry(url string) *CacheEntry {
+	data, err := os.ReadFile(c.entryPath(url))
+	if err != nil {
+		return nil
+	}
+
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.URL != redactURL(url) || !c.has(entry.SHA256) {
+		return nil
+	}
+	return &entry
+}
+
+// restore copies the cached archive with the given digest to destPath,
+// discarding it from the cache if it no longer matches its digest
+func (c *Cache) restore(digest, destPath string) error {
+	if err := copyFile(c.blobPath(digest), destPath); err != nil {
+		return err
+	}
+
+	got, err := fileSHA256(destPath)
+	if err != nil {
+		return err
+	}
+	if got != digest {
+		os.Remove(c.blobPath(digest))
+		return fmt.Errorf("cached archive %s is corrupt", shortSum(digest))
+	}
+	return nil
+}
+
+// store adds a downloaded archive to the cache and records it for url
+func (c *Cache) store(url, path string, entry CacheEntry) error {
+	if c.readOnly {
+		return nil
+	}
+
+	digest, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !c.has(digest) {
+		blob := c.blobPath(digest)
+		if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+			return err
+		}
+		// Copy to a temporary name first so a concurrent install never sees a partial file
+		tmp := blob + ".tmp-" + fmt.Sprint(os.Getpid())
+		if err := copyFile(path, tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Chmod(tmp, 0644); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Rename(tmp, blob); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	// Secrets in the URL are not written to the cache
+	entry.URL = redactURL(url)
+	entry.SHA256 = digest
+	entry.Size = info.Size()
+	entry.FetchedAt = time.Now().UTC()
+	return writeJSONFile(c.entryPath(url), entry)
+}
+
+// entries returns all index entries, sorted by URL
+func (c *Cache) entries() ([]CacheEntry, error) {
+	paths, err := filepath.Glob(filepath.Join(c.dir, "urls", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []CacheEntry
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var entry CacheEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			fmt.Printf("  Warning: ignoring invalid cache entry %s: %v\n", path, err)
+			continue
+		}
+		entry.path = path
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].URL < entries[j].URL
+	})
+	return entries, nil
+}
+
+// List prints the cached archives
+func (c *Cache) List() error {
+	entries, err := c.entries()
+	if err != nil {
+		return fmt.Errorf("failed to read cache: %w", err)
+	}
+
+	fmt.Printf("Cache directory: %s\n", c.dir)
+	if len(entries) == 0 {
+		fmt.Println("No cached archives")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SHA256\tSIZE\tFETCHED\tURL")
+	for _, e := range entries {
+		if !c.has(e.SHA256) {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", shortSum(e.SHA256), e.Size, formatTime(e.FetchedAt), e.URL)
+	}
+	return w.Flush()
+}
+
+// Prune removes cached archives that no installed package was installed
+// from, along with index entries pointing to removed archives
+func (c *Cache) Prune() error {
+	receipts, err := ListReceipts()
+	if err != nil {
+		return fmt.Errorf("failed to read install receipts: %w", err)
+	}
+	keep := make(map[string]bool)
+	for _, r := range receipts {
+		if r.ArchiveSHA256 != "" {
+			keep[r.ArchiveSHA256] = true
+		}
+	}
+
+	blobs, err := os.ReadDir(filepath.Join(c.dir, "archives"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read cache: %w", err)
+	}
+
+	removed, freed := 0, int64(0)
+	for _, blob := range blobs {
+		if keep[blob.Name()] {
+			continue
+		}
+		path := filepath.Join(c.dir, "archives", blob.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		removed++
+		freed += info.Size()
+	}
+
+	entries, err := c.entries()
+	if err != nil {
+		return fmt.Errorf("failed to read cache: %w", err)
+	}
+	for _, e := range entries {
+		if !c.has(e.SHA256) {
+			if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	fmt.Printf("Removed %d cached archive(s), %d bytes freed, kept %d in use by installed packages\n",
+		removed, freed, len(blobs)-removed)
+	return nil
+}
+
+// writeJSONFile writes v as JSON through a temporary file and rename
+func writeJSONFile(path string, v any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp-" + fmt.Sprint(os.Getpid())
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// describeEntry summarizes a cache entry for log output
+func describeEntry(e *CacheEntry) string {
+	parts := []string{"sha256 " + shortSum(e.SHA256)}
+	if e.ETag != "" {
+		parts = append(parts, "etag "+e.ETag)
+	} else if e.LastModified != "" {
+		parts = append(parts, "modified "+e.LastModified)
+	}
+	return strings.Join(parts, ", ")
+}
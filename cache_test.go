@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCacheStoreAndRestore(t *testing.T) {
+	cache := NewCache(t.TempDir())
+	archive := filepath.Join(t.TempDir(), "archive")
+	if err := os.WriteFile(archive, []byte("foo"), 0600); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+
+	url := "https://example.com/tool.tar.gz"
+	if err := cache.store(url, archive, CacheEntry{ETag: `"v1"`}); err != nil {
+		t.Fatalf("store() unexpected error: %v", err)
+	}
+
+	entry := cache.entry(url)
+	if entry == nil {
+		t.Fatal("expected cache entry for URL")
+	}
+	const digest = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	if entry.SHA256 != digest || entry.Size != 3 || entry.ETag != `"v1"` {
+		t.Errorf("unexpected cache entry: %+v", entry)
+	}
+
+	dest := filepath.Join(t.TempDir(), "restored")
+	if err := cache.restore(digest, dest); err != nil {
+		t.Fatalf("restore() unexpected error: %v", err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "foo" {
+		t.Errorf("unexpected restored content %q", data)
+	}
+
+	// A corrupt archive is dropped from the cache
+	if err := os.WriteFile(cache.blobPath(digest), []byte("bar"), 0644); err != nil {
+		t.Fatalf("failed to corrupt archive: %v", err)
+	}
+	if err := cache.restore(digest, dest); err == nil {
+		t.Error("restore() expected error for corrupt archive, got nil")
+	}
+	if cache.has(digest) || cache.entry(url) != nil {
+		t.Error("expected corrupt archive to be removed from the cache")
+	}
+}
+
+func TestDownloadArchiveUsesCache(t *testing.T) {
+	var requests, notModified atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("foo"))
+	}))
+	defer server.Close()
+
+	opts := testDownloadOptions
+	opts.Cache = NewCache(t.TempDir())
+
+	for i := 0; i < 2; i++ {
+		data, err := downloadTo(t, server.URL, opts)
+		if err != nil {
+			t.Fatalf("DownloadArchive() unexpected error: %v", err)
+		}
+		if string(data) != "foo" {
+			t.Errorf("unexpected content %q", data)
+		}
+	}
+	if notModified.Load() != 1 {
+		t.Errorf("expected the second download to be revalidated, got %d not modified responses", notModified.Load())
+	}
+
+	// A known digest is served from the cache without a request
+	opts.SHA256 = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	before := requests.Load()
+	data, err := downloadTo(t, server.URL, opts)
+	if err != nil {
+		t.Fatalf("DownloadArchive() unexpected error: %v", err)
+	}
+	if string(data) != "foo" {
+		t.Errorf("unexpected content %q", data)
+	}
+	if requests.Load() != before {
+		t.Errorf("expected no request for a cached digest, got %d", requests.Load()-before)
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	t.Setenv("TGZETUP_STATE_DIR", t.TempDir())
+	cache := NewCache(t.TempDir())
+
+	for _, content := range []string{"installed", "unused"} {
+		archive := filepath.Join(t.TempDir(), "archive")
+		if err := os.WriteFile(archive, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write archive: %v", err)
+		}
+		if err := cache.store("https://example.com/"+content, archive, CacheEntry{}); err != nil {
+			t.Fatalf("store() unexpected error: %v", err)
+		}
+	}
+
+	installed := cache.entry("https://example.com/installed")
+	receipt := &Receipt{Name: "tool", ArchiveSHA256: installed.SHA256}
+	if err := receipt.Save(); err != nil {
+		t.Fatalf("Save() unexpected error: %v", err)
+	}
+
+	if err := cache.Prune(); err != nil {
+		t.Fatalf("Prune() unexpected error: %v", err)
+	}
+	if cache.entry("https://example.com/installed") == nil {
+		t.Error("expected archive of installed package to be kept")
+	}
+	if cache.entry("https://example.com/unused") != nil {
+		t.Error("expected unused archive to be pruned")
+	}
+	entries, err := cache.entries()
+	if err != nil {
+		t.Fatalf("entries() unexpected error: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected index entry of pruned archive to be removed, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// fileSHA256 returns the hex encoded SHA-256 digest of a file
+func fileSHA256(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// normalizeChecksum validates a SHA-256 checksum and returns it in lowercase hex
+// An optional "sha256:" prefix is accepted
+func normalizeChecksum(checksum string) (string, error) {
+	sum := strings.ToLower(strings.TrimSpace(checksum))
+	sum = strings.TrimPrefix(sum, "sha256:")
+
+	if len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid sha256 checksum %q: expected %d hex characters", checksum, sha256.Size*2)
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return "", fmt.Errorf("invalid sha256 checksum %q: not hex encoded", checksum)
+	}
+	return sum, nil
+}
+
+// resolveChecksumURL resolves a checksum file reference relative to the archive URL
+// A reference relative to a plain archive path is resolved as a path.
+func resolveChecksumURL(archiveURL, ref string) (string, error) {
+	if archiveURL == stdinSource {
+		if strings.Contains(ref, "://") || filepath.IsAbs(ref) {
+			return ref, nil
+		}
+		return "", fmt.Errorf("relative checksum_url %s cannot be resolved when reading the archive from standard input", ref)
+	}
+	if !strings.Contains(archiveURL, "://") && !strings.Contains(ref, "://") {
+		if filepath.IsAbs(ref) {
+			return ref, nil
+		}
+		return filepath.Join(filepath.Dir(archiveURL), ref), nil
+	}
+
+	base, err := url.Parse(archiveURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid archive URL: %w", err)
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid checksum URL: %w", err)
+	}
+	return base.ResolveReference(refURL).String(), nil
+}
+
+// archiveName returns the file name of the archive referenced by a URL
+func archiveName(archiveURL string) string {
+	if !strings.Contains(archiveURL, "://") {
+		return filepath.Base(archiveURL)
+	}
+	if u, err := url.Parse(archiveURL); err == nil && u.Path != "" {
+		return path.Base(u.Path)
+	}
+	return path.Base(archiveURL)
+}
+
+// FetchChecksum downloads a SHA256SUMS-style file and returns the checksum for the named archive
+// Local checksum files are read directly.
+func FetchChecksum(sumsURL string, name string, opts DownloadOptions) (string, error) {
+	fmt.Printf("Fetching checksums from %s...\n", redactURL(sumsURL))
+
+	data, err := fetchFile(sumsURL, "checksum file", opts)
+	if err != nil {
+		return "", err
+	}
+	return parseChecksumFile(data, name)
+}
+
+// parseChecksumFile finds the checksum for name in a SHA256SUMS-style file
+// Both the GNU ("<hash>  <name>") and BSD ("SHA256 (<name>) = <hash>") formats
+// are understood. A file containing a single bare hash applies to any name.
+func parseChecksumFile(data []byte, name string) (string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read checksum file: %w", err)
+	}
+
+	// A single bare hash (e.g. archive.tar.gz.sha256)
+	if len(lines) == 1 && !strings.ContainsAny(lines[0], " \t") {
+		return normalizeChecksum(lines[0])
+	}
+
+	for _, line := range lines {
+		var sum, entry string
+
+		if rest, ok := strings.CutPrefix(line, "SHA256 ("); ok {
+			// BSD style
+			before, after, found := strings.Cut(rest, ") = ")
+			if !found {
+				continue
+			}
+			entry, sum = before, after
+		} else {
+			// GNU style, "*" marks binary mode
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+			sum, entry = fields[0], strings.TrimPrefix(fields[1], "*")
+		}
+
+		if path.Base(entry) == name {
+			return normalizeChecksum(sum)
+		}
+	}
+
+	return "", fmt.Errorf("no checksum for %s found in checksum file", name)
+}
+
+// VerifyChecksum verifies that the file at archivePath has the expected SHA-256 digest
+func VerifyChecksum(archivePath string, expected string) error {
+	fmt.Println("Verifying archive checksum...")
+
+	want, err := normalizeChecksum(expected)
+	if err != nil {
+		return err
+	}
+
+	got, err := fileSHA256(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to compute checksum: %w", err)
+	}
+
+	if got != want {
+		fmt.Printf("  [FAIL] sha256 mismatch\n")
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", want, got)
+	}
+
+	fmt.Printf("  [OK] sha256 %s\n", got)
+	return nil
+}
+
+// expectedChecksum determines the checksum an archive must match
+// The command line checksum takes precedence over the mapping file.
+// An empty result means no checksum was configured.
+func expectedChecksum(archiveURL string, config *Config, flagChecksum string, opts DownloadOptions) (string, error) {
+	if flagChecksum != "" {
+		return normalizeChecksum(flagChecksum)
+	}
+	if config.Checksum != "" {
+		return normalizeChecksum(config.Checksum)
+	}
+	if config.ChecksumURL != "" {
+		sumsURL, err := resolveChecksumURL(archiveURL, config.ChecksumURL)
+		if err != nil {
+			return "", err
+		}
+		return FetchChecksum(sumsURL, archiveName(archiveURL), opts)
+	}
+	return "", nil
+}
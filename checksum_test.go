@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseChecksumFile(t *testing.T) {
+	const sumA = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	const sumB = "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9"
+
+	tests := []struct {
+		name    string
+		data    string
+		file    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "gnu format",
+			data: sumA + "  tool-1.0.0-linux-amd64.tar.gz\n" + sumB + "  tool-1.0.0-linux-arm64.tar.gz\n",
+			file: "tool-1.0.0-linux-arm64.tar.gz",
+			want: sumB,
+		},
+		{
+			name: "gnu binary mode with directory",
+			data: sumA + " *dist/tool.tar.gz\n",
+			file: "tool.tar.gz",
+			want: sumA,
+		},
+		{
+			name: "bsd format",
+			data: "SHA256 (tool.tar.gz) = " + sumA + "\n",
+			file: "tool.tar.gz",
+			want: sumA,
+		},
+		{
+			name: "single bare hash",
+			data: sumA + "\n",
+			file: "anything.tar.gz",
+			want: sumA,
+		},
+		{
+			name:    "missing entry",
+			data:    sumA + "  other.tar.gz\n" + sumB + "  another.tar.gz\n",
+			file:    "tool.tar.gz",
+			wantErr: true,
+		},
+		{
+			name:    "invalid hash",
+			data:    "nothex  tool.tar.gz\n",
+			file:    "tool.tar.gz",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseChecksumFile([]byte(tt.data), tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseChecksumFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseChecksumFile() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.tar.gz")
+	if err := os.WriteFile(path, []byte("foo"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	// sha256("foo")
+	if err := VerifyChecksum(path, "sha256:2C26B46B68FFC68FF99B453C1D30413413422D706483BFA0F98A5E886266E7AE"); err != nil {
+		t.Errorf("VerifyChecksum() unexpected error: %v", err)
+	}
+	if err := VerifyChecksum(path, "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9"); err == nil {
+		t.Error("VerifyChecksum() expected error for mismatched checksum, got nil")
+	}
+}
+
+func TestResolveChecksumURL(t *testing.T) {
+	tests := []struct {
+		archive string
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{"https://example.com/releases/v1.0.0/tool.tar.gz", "SHA256SUMS", "https://example.com/releases/v1.0.0/SHA256SUMS", false},
+		{"file:///srv/dist/tool.tar.gz", "SHA256SUMS", "file:///srv/dist/SHA256SUMS", false},
+		{"/srv/dist/tool.tar.gz", "SHA256SUMS", "/srv/dist/SHA256SUMS", false},
+		{"/srv/dist/tool.tar.gz", "https://example.com/SHA256SUMS", "https://example.com/SHA256SUMS", false},
+		{"-", "/srv/dist/SHA256SUMS", "/srv/dist/SHA256SUMS", false},
+		{"-", "SHA256SUMS", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := resolveChecksumURL(tt.archive, tt.ref)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("resolveChecksumURL(%s, %s) error = %v, wantErr %v", tt.archive, tt.ref, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("resolveChecksumURL(%s, %s) = %s, want %s", tt.archive, tt.ref, got, tt.want)
+		}
+	}
+}
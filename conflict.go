@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// conflict is a file an installation would replace that it does not own
+type conflict struct {
+	path  string
+	owner string // package owning the file, empty when tgzetup did not install it
+}
+
+// describe explains why the file is a conflict
+func (c conflict) describe() string {
+	if c.owner == "" {
+		return fmt.Sprintf("%s (not installed by tgzetup)", c.path)
+	}
+	return fmt.Sprintf("%s (installed by package %s)", c.path, c.owner)
+}
+
+// findConflicts returns the staged files that are owned by another package or
+// exist without being recorded in the receipt of the package itself
+func findConflicts(name string, tx *transaction) ([]conflict, error) {
+	receipts, err := ListReceipts()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load install receipts: %w", err)
+	}
+
+	owners := make(map[string]string)
+	var own *Receipt
+	for _, r := range receipts {
+		if r.Name == name {
+			own = r
+			continue
+		}
+		for _, f := range r.Files {
+			owners[f.Path] = r.Name
+		}
+	}
+
+	var conflicts []conflict
+	for _, f := range tx.staged {
+		if owner, ok := owners[f.target]; ok {
+			conflicts = append(conflicts, conflict{path: f.target, owner: owner})
+			continue
+		}
+		// Files from an earlier installation of the same package are replaced as usual
+		if own != nil && own.hasFile(f.target) {
+			continue
+		}
+		if _, err := os.Lstat(f.target); err == nil {
+			conflicts = append(conflicts, conflict{path: f.target})
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return conflicts, nil
+}
+
+// checkConflicts refuses to replace files the package does not own, listing
+// all of them at once. With overwrite they are replaced after a warning.
+func checkConflicts(name string, tx *transaction, overwrite bool) ([]conflict, error) {
+	conflicts, err := findConflicts(name, tx)
+	if err != nil {
+		return nil, err
+	}
+	if len(conflicts) == 0 {
+		return nil, nil
+	}
+
+	if overwrite {
+		for _, c := range conflicts {
+			fmt.Printf("  Warning: overwriting %s\n", c.describe())
+		}
+		return conflicts, nil
+	}
+
+	lines := make([]string, len(conflicts))
+	for i, c := range conflicts {
+		lines[i] = "  " + c.describe()
+	}
+	return nil, fmt.Errorf("%d file(s) would be overwritten, use -overwrite to replace them:\n%s",
+		len(conflicts), strings.Join(lines, "\n"))
+}
+
+// releaseConflicts drops overwritten files from the receipts of the packages
+// that owned them, so uninstalling those packages leaves the files alone
+func releaseConflicts(conflicts []conflict) {
+	released := make(map[string]*Receipt)
+	for _, c := range conflicts {
+		if c.owner == "" {
+			continue
+		}
+		r, ok := released[c.owner]
+		if !ok {
+			var err error
+			r, err = LoadReceipt(c.owner)
+			if err != nil {
+				fmt.Printf("  Warning: %v\n", err)
+			}
+			released[c.owner] = r
+		}
+		if r != nil {
+			r.removeFile(c.path)
+		}
+	}
+
+	for _, r := range released {
+		if r == nil {
+			continue
+		}
+		if err := r.Save(); err != nil {
+			fmt.Printf("  Warning: %v\n", err)
+		}
+	}
+}
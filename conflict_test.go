@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConflicts(t *testing.T) {
+	t.Setenv("TGZETUP_STATE_DIR", t.TempDir())
+	dir := t.TempDir()
+
+	owned := filepath.Join(dir, "owned")         // installed by another package
+	unmanaged := filepath.Join(dir, "unmanaged") // e.g. installed by apt
+	previous := filepath.Join(dir, "previous")   // from an earlier installation of the package
+	created := filepath.Join(dir, "created")     // does not exist yet
+	for _, path := range []string{owned, unmanaged, previous} {
+		if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+	}
+
+	other := &Receipt{Name: "other", Files: []FileRecord{{Path: owned}}}
+	own := &Receipt{Name: "tool", Files: []FileRecord{{Path: previous}}}
+	for _, r := range []*Receipt{other, own} {
+		if err := r.Save(); err != nil {
+			t.Fatalf("Save() unexpected error: %v", err)
+		}
+	}
+
+	tx := newTransaction(&Receipt{Name: "tool"}, nil)
+	for _, path := range []string{owned, unmanaged, previous, created} {
+		if err := tx.stageFile(path, writeStaged("new")); err != nil {
+			t.Fatalf("stageFile() unexpected error: %v", err)
+		}
+	}
+	defer tx.rollback()
+
+	// Every conflict is reported at once
+	_, err := checkConflicts("tool", tx, false)
+	if err == nil {
+		t.Fatal("checkConflicts() expected error, got nil")
+	}
+	for _, want := range []string{owned + " (installed by package other)", unmanaged + " (not installed by tgzetup)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got %q", want, err)
+		}
+	}
+	for _, path := range []string{previous, created} {
+		if strings.Contains(err.Error(), path+" ") {
+			t.Errorf("expected %s not to be a conflict, got %q", path, err)
+		}
+	}
+
+	conflicts, err := checkConflicts("tool", tx, true)
+	if err != nil {
+		t.Fatalf("checkConflicts() with overwrite unexpected error: %v", err)
+	}
+	if len(conflicts) != 2 {
+		t.Fatalf("expected 2 conflicts, got %v", conflicts)
+	}
+
+	// Overwritten files are no longer owned by the other package
+	releaseConflicts(conflicts)
+	reloaded, err := LoadReceipt("other")
+	if err != nil {
+		t.Fatalf("LoadReceipt() unexpected error: %v", err)
+	}
+	if reloaded.hasFile(owned) {
+		t.Errorf("expected %s to be released by package other", owned)
+	}
+}
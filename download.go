@@ -1,16 +1,87 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// DownloadOptions controls timeouts and retries of downloads
+type DownloadOptions struct {
+	// Timeout limits connecting, waiting for a response and waiting for
+	// more data, so a stalled transfer is retried instead of hanging
+	Timeout time.Duration
+	// Retries is the number of times a failed download is retried
+	Retries int
+	// RetryWait is the delay before the first retry, doubled for every further retry
+	RetryWait time.Duration
+	// Cache is consulted before downloading and stores new downloads, nil disables it
+	Cache *Cache
+	// SHA256 is the expected digest of the archive, if known. A cached
+	// archive with this digest is used without contacting the server.
+	SHA256 string
+	// Auth is the authentication from the mapping file, nil when not configured
+	Auth *Auth
+	// Proxy is used for all requests instead of HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+	Proxy string
+	// CAFile is a PEM bundle of certificates trusted in addition to the system roots
+	CAFile string
+	// InsecureSkipVerify disables TLS certificate verification
+	InsecureSkipVerify bool
+}
+
+// defaultDownloadOptions are used unless overridden on the command line
+var defaultDownloadOptions = DownloadOptions{
+	Timeout:   30 * time.Second,
+	Retries:   3,
+	RetryWait: time.Second,
+}
+
+// maxRetryWait caps the exponential backoff between retries
+const maxRetryWait = 30 * time.Second
+
+// statusError is an HTTP response with an unexpected status code
+type statusError struct {
+	code       int
+	status     string
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("bad status: %s", e.status)
+}
+
+// temporary reports whether retrying the request may succeed
+func (e *statusError) temporary() bool {
+	return e.code >= 500 || e.code == http.StatusTooManyRequests || e.code == http.StatusRequestTimeout
+}
+
+// transferError is a failure while receiving data, which can be resumed
+type transferError struct {
+	err error
+}
+
+func (e *transferError) Error() string { return e.err.Error() }
+func (e *transferError) Unwrap() error { return e.err }
+
 // DownloadArchive downloads a file from the given URL to the destination path
-func DownloadArchive(url string, destPath string) error {
-	fmt.Printf("Downloading archive from %s...\n", url)
+// Interrupted transfers are retried with exponential backoff and resumed
+// with HTTP range requests where the server supports them.
+func DownloadArchive(url string, destPath string, opts DownloadOptions) error {
+	fmt.Printf("Downloading archive from %s...\n", redactURL(url))
 
 	// Create the destination directory if it doesn't exist
 	destDir := filepath.Dir(destPath)
@@ -18,6 +89,30 @@ func DownloadArchive(url string, destPath string) error {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
 
+	// A known digest identifies the archive without asking the server
+	if opts.Cache != nil && opts.SHA256 != "" && opts.Cache.has(opts.SHA256) {
+		err := opts.Cache.restore(opts.SHA256, destPath)
+		if err == nil {
+			fmt.Printf("  Using cached archive (sha256 %s)\n", shortSum(opts.SHA256))
+			return nil
+		}
+		fmt.Printf("  Warning: %v\n", err)
+	}
+
+	creds, err := resolveCredentials(opts.Auth, url)
+	if err != nil {
+		return err
+	}
+	if creds.source != "" {
+		fmt.Printf("  Authenticating with %s\n", creds.source)
+	}
+
+	client, err := newDownloadClient(opts)
+	if err != nil {
+		return err
+	}
+	client.CheckRedirect = creds.checkRedirect
+
 	// Create the destination file
 	out, err := os.Create(destPath)
 	if err != nil {
@@ -25,24 +120,454 @@ func DownloadArchive(url string, destPath string) error {
 	}
 	defer out.Close()
 
-	// Download the file
-	resp, err := http.Get(url)
+	d := &download{
+		client:   client,
+		creds:    creds,
+		url:      url,
+		out:      out,
+		timeout:  opts.Timeout,
+		cache:    opts.Cache,
+		progress: newProgress("Downloading", 0),
+	}
+	if opts.Cache != nil {
+		d.cached = opts.Cache.entry(url)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := d.attempt()
+		d.progress.clear()
+		if err == nil {
+			break
+		}
+		if !retryable(err) || attempt >= opts.Retries {
+			return fmt.Errorf("failed to download file: %w", err)
+		}
+
+		wait := retryWait(opts.RetryWait, attempt, err)
+		fmt.Printf("  Download failed after %d bytes: %v\n", d.written, err)
+		fmt.Printf("  Retrying in %s (%d/%d)...\n", wait, attempt+1, opts.Retries)
+		time.Sleep(wait)
+	}
+
+	if d.fromCache {
+		fmt.Printf("  Archive not modified, using cached copy (%s)\n", describeEntry(d.cached))
+		return nil
+	}
+	fmt.Printf("Downloaded %d bytes\n", d.written)
+
+	// A failure to cache the archive does not affect the installation
+	if opts.Cache != nil {
+		entry := CacheEntry{ETag: d.etag, LastModified: d.lastModified}
+		if err := opts.Cache.store(url, destPath, entry); err != nil {
+			fmt.Printf("  Warning: failed to cache archive: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// maxFetchSize limits the size of checksum and signature files
+const maxFetchSize = 1 << 20
+
+// fetchFile downloads a small file such as a checksum or signature file
+// with the same client settings and credentials as the archive. Local
+// files are read directly.
+func fetchFile(fileURL, what string, opts DownloadOptions) ([]byte, error) {
+	path, local, err := localPath(fileURL)
 	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
+		return nil, err
+	}
+	if local {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", what, err)
+		}
+		return data, nil
+	}
+
+	creds, err := resolveCredentials(opts.Auth, fileURL)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newDownloadClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	client.CheckRedirect = creds.checkRedirect
+
+	req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", what, err)
+	}
+	creds.apply(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		redactError(err)
+		return nil, fmt.Errorf("failed to download %s: %w", what, err)
 	}
 	defer resp.Body.Close()
 
-	// Check HTTP response status
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+		return nil, fmt.Errorf("failed to download %s: bad status: %s", what, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", what, err)
+	}
+	if len(data) > maxFetchSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", what, maxFetchSize)
+	}
+	return data, nil
+}
+
+// newDownloadClient creates the HTTP client used for archives and checksum
+// files, applying the proxy, TLS settings and the connect and response
+// timeouts. The body is guarded separately so large downloads are not cut off.
+func newDownloadClient(opts DownloadOptions) (*http.Client, error) {
+	// The default transport honors HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.Timeout > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: opts.Timeout, KeepAlive: 30 * time.Second}).DialContext
+		transport.TLSHandshakeTimeout = opts.Timeout
+		transport.ResponseHeaderTimeout = opts.Timeout
+	}
+
+	if opts.Proxy != "" {
+		proxyURL, err := parseProxyURL(opts.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if opts.CAFile != "" || opts.InsecureSkipVerify {
+		tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+		if opts.CAFile != "" {
+			pool, err := loadCAFile(opts.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// parseProxyURL parses the -proxy option, defaulting to an HTTP proxy when
+// no scheme is given
+func parseProxyURL(value string) (*url.URL, error) {
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy URL %s: unsupported scheme %s", redactURL(value), u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %s: missing host", redactURL(value))
+	}
+	return u, nil
+}
+
+// loadCAFile returns the system certificate pool extended with the
+// certificates of a PEM file
+func loadCAFile(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in CA file %s", path)
 	}
+	return pool, nil
+}
+
+// download is the state of a download across attempts
+type download struct {
+	client  *http.Client
+	creds   *credentials
+	url     string
+	out     *os.File
+	timeout time.Duration
+	written int64
+	// validator identifies the version being downloaded so a resumed
+	// request never mixes the data of two different files
+	validator    string
+	etag         string
+	lastModified string
+	// cached is the archive the cache holds for the URL, revalidated with
+	// the server on the first request
+	cache     *Cache
+	cached    *CacheEntry
+	fromCache bool
+	progress  *progress
+}
 
-	// Write the response body to file
-	size, err := io.Copy(out, resp.Body)
+// attempt requests the remaining part of the file and appends it to out
+func (d *download) attempt() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return err
+	}
+	d.creds.apply(req)
+	if d.written > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.written))
+		if d.validator != "" {
+			req.Header.Set("If-Range", d.validator)
+		}
+	}
+	if d.written == 0 && d.cached != nil {
+		if d.cached.ETag != "" {
+			req.Header.Set("If-None-Match", d.cached.ETag)
+		}
+		if d.cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", d.cached.LastModified)
+		}
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		redactError(err)
+		if transientRequestError(err) {
+			return &transferError{err: err}
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && d.cached != nil:
+		return d.useCached()
+	case resp.StatusCode == http.StatusOK:
+		// A full response, either the first attempt or the server cannot resume
+		if d.written > 0 {
+			d.progress.clear()
+			fmt.Println("  Server does not support resuming, restarting download")
+			if err := d.restart(); err != nil {
+				return err
+			}
+		}
+	case resp.StatusCode == http.StatusPartialContent && d.written > 0:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != d.written {
+			return fmt.Errorf("unexpected Content-Range %q when resuming at byte %d", resp.Header.Get("Content-Range"), d.written)
+		}
+		d.progress.clear()
+		fmt.Printf("  Resuming download at byte %d\n", d.written)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && d.written > 0:
+		// Everything was received before the connection dropped
+		if size, ok := contentRangeSize(resp.Header.Get("Content-Range")); ok && size == d.written {
+			return nil
+		}
+		return &statusError{code: resp.StatusCode, status: resp.Status}
+	default:
+		return &statusError{
+			code:       resp.StatusCode,
+			status:     resp.Status,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	if d.written == 0 {
+		d.validator = responseValidator(resp.Header)
+		d.etag = resp.Header.Get("ETag")
+		d.lastModified = resp.Header.Get("Last-Modified")
+	}
+
+	// The Content-Length of a partial response only covers the remaining bytes
+	if resp.ContentLength > 0 {
+		d.progress.setTotal(d.written + resp.ContentLength)
+	}
+	d.progress.reset(d.written)
+
+	// Cancel the request when no data arrives within the timeout
+	body := io.Reader(resp.Body)
+	if d.timeout > 0 {
+		timer := time.AfterFunc(d.timeout, cancel)
+		defer timer.Stop()
+		body = &stallReader{r: resp.Body, timer: timer, timeout: d.timeout}
+	}
+
+	// Write the response body to file, telling read and write errors apart
+	reader := &errorReader{r: body}
+	n, err := io.Copy(io.MultiWriter(d.out, d.progress), reader)
+	d.written += n
+	if err != nil {
+		if reader.err != nil {
+			if ctx.Err() != nil {
+				return &transferError{err: fmt.Errorf("no data received for %s", d.timeout)}
+			}
+			return &transferError{err: err}
+		}
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+// useCached writes the cached archive to out after the server confirmed
+// that it is unchanged. A corrupt cached copy is dropped and the download
+// retried without revalidation.
+func (d *download) useCached() error {
+	blob := d.cache.blobPath(d.cached.SHA256)
+	f, err := os.Open(blob)
+	if err != nil {
+		d.cached = nil
+		return &transferError{err: fmt.Errorf("failed to read cached archive: %w", err)}
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(d.out, hash), f)
+	d.written = n
 	if err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
-	fmt.Printf("Downloaded %d bytes\n", size)
+	if hex.EncodeToString(hash.Sum(nil)) != d.cached.SHA256 {
+		os.Remove(blob)
+		d.cached = nil
+		if err := d.restart(); err != nil {
+			return err
+		}
+		return &transferError{err: fmt.Errorf("cached archive is corrupt")}
+	}
+
+	d.fromCache = true
 	return nil
 }
+
+// restart discards the data received so far
+func (d *download) restart() error {
+	if err := d.out.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := d.out.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	d.written = 0
+	return nil
+}
+
+// stallReader extends a timer every time data arrives
+type stallReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.timer.Reset(s.timeout)
+	}
+	return n, err
+}
+
+// errorReader remembers the error returned by the underlying reader
+type errorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errorReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF {
+		e.err = err
+	}
+	return n, err
+}
+
+// retryable reports whether a failed attempt should be retried
+func retryable(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.temporary()
+	}
+	var transferErr *transferError
+	return errors.As(err, &transferErr)
+}
+
+// transientRequestError reports whether a failed request may succeed when
+// retried, e.g. a refused or reset connection but not an unsupported scheme
+func transientRequestError(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryWait returns the delay before retry number attempt+1, honoring a
+// Retry-After header sent by the server
+func retryWait(base time.Duration, attempt int, err error) time.Duration {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.retryAfter > 0 {
+		return min(statusErr.retryAfter, maxRetryWait)
+	}
+
+	wait := base
+	for i := 0; i < attempt && wait < maxRetryWait; i++ {
+		wait *= 2
+	}
+	return min(wait, maxRetryWait)
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// responseValidator returns the ETag, or else the Last-Modified date, for If-Range
+// Weak ETags cannot be used for range requests
+func responseValidator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+// contentRangeStart parses the first byte position of a Content-Range header
+// such as "bytes 100-199/200"
+func contentRangeStart(value string) (int64, bool) {
+	spec, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	return n, err == nil
+}
+
+// contentRangeSize parses the complete length of a Content-Range header
+// such as "bytes */200"
+func contentRangeSize(value string) (int64, bool) {
+	_, size, ok := strings.Cut(value, "/")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	return n, err == nil
+}
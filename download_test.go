@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testDownloadOptions retries quickly so tests do not wait for the backoff
+var testDownloadOptions = DownloadOptions{Timeout: 5 * time.Second, Retries: 3, RetryWait: time.Millisecond}
+
+// testContent returns a payload large enough to be cut off mid-transfer
+func testContent() []byte {
+	return bytes.Repeat([]byte("tgzetup download test\n"), 10000)
+}
+
+// droppingWriter aborts the connection after limit bytes of the body
+type droppingWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *droppingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		w.ResponseWriter.Write(p[:w.limit])
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.limit -= len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func downloadTo(t *testing.T, url string, opts DownloadOptions) ([]byte, error) {
+	t.Helper()
+	destPath := filepath.Join(t.TempDir(), "archive")
+	if err := DownloadArchive(url, destPath, opts); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(destPath)
+	if err != nil {
+		t.Fatalf("failed to read download: %v", err)
+	}
+	return data, nil
+}
+
+func TestDownloadArchiveResumesDroppedConnection(t *testing.T) {
+	content := testContent()
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var requests atomic.Int32
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		ranges = append(ranges, r.Header.Get("Range"))
+		// The first two attempts are cut off after a part of the file
+		if n <= 2 {
+			w = &droppingWriter{ResponseWriter: w, limit: 50000}
+		}
+		http.ServeContent(w, r, "archive.tar.gz", modTime, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	data, err := downloadTo(t, server.URL, testDownloadOptions)
+	if err != nil {
+		t.Fatalf("DownloadArchive() unexpected error: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatalf("downloaded %d bytes, want %d identical bytes", len(data), len(content))
+	}
+	if requests.Load() != 3 {
+		t.Errorf("expected 3 requests, got %d", requests.Load())
+	}
+	if ranges[0] != "" || !strings.HasPrefix(ranges[1], "bytes=") || !strings.HasPrefix(ranges[2], "bytes=") {
+		t.Errorf("expected retries to request the remaining range, got %q", ranges)
+	}
+}
+
+func TestDownloadArchiveRestartsWithoutRangeSupport(t *testing.T) {
+	content := testContent()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Ignores Range and always sends the whole file
+		if requests.Add(1) == 1 {
+			w = &droppingWriter{ResponseWriter: w, limit: 50000}
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	data, err := downloadTo(t, server.URL, testDownloadOptions)
+	if err != nil {
+		t.Fatalf("DownloadArchive() unexpected error: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatalf("downloaded %d bytes, want %d identical bytes", len(data), len(content))
+	}
+}
+
+func TestDownloadArchiveRetriesServerErrors(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("archive"))
+	}))
+	defer server.Close()
+
+	data, err := downloadTo(t, server.URL, testDownloadOptions)
+	if err != nil {
+		t.Fatalf("DownloadArchive() unexpected error: %v", err)
+	}
+	if string(data) != "archive" {
+		t.Errorf("unexpected content %q", data)
+	}
+}
+
+func TestDownloadArchiveRetriesStalledTransfer(t *testing.T) {
+	content := testContent()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Content-Length", "1000000")
+			w.Write(content[:1000])
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		http.ServeContent(w, r, "archive.tar.gz", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	opts := testDownloadOptions
+	opts.Timeout = 100 * time.Millisecond
+	data, err := downloadTo(t, server.URL, opts)
+	if err != nil {
+		t.Fatalf("DownloadArchive() unexpected error: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatalf("downloaded %d bytes, want %d identical bytes", len(data), len(content))
+	}
+}
+
+func TestDownloadArchiveFailures(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantRequests int32
+	}{
+		{"not found is not retried", http.StatusNotFound, 1},
+		{"retries are exhausted", http.StatusBadGateway, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			if _, err := downloadTo(t, server.URL, testDownloadOptions); err == nil {
+				t.Fatal("DownloadArchive() expected error, got nil")
+			}
+			if requests.Load() != tt.wantRequests {
+				t.Errorf("expected %d requests, got %d", tt.wantRequests, requests.Load())
+			}
+		})
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	tests := []struct {
+		attempt int
+		err     error
+		want    time.Duration
+	}{
+		{0, &transferError{}, time.Second},
+		{1, &transferError{}, 2 * time.Second},
+		{3, &transferError{}, 8 * time.Second},
+		{10, &transferError{}, maxRetryWait},
+		{0, &statusError{code: 503, retryAfter: 5 * time.Second}, 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := retryWait(time.Second, tt.attempt, tt.err); got != tt.want {
+			t.Errorf("retryWait(attempt %d, %v) = %s, want %s", tt.attempt, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadArchiveTLSOptions(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("archive"))
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0644); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+	invalidCAFile := filepath.Join(t.TempDir(), "invalid.pem")
+	if err := os.WriteFile(invalidCAFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(*DownloadOptions)
+		wantErr bool
+	}{
+		{"untrusted certificate", func(o *DownloadOptions) {}, true},
+		{"ca file", func(o *DownloadOptions) { o.CAFile = caFile }, false},
+		{"invalid ca file", func(o *DownloadOptions) { o.CAFile = invalidCAFile }, true},
+		{"insecure skip verify", func(o *DownloadOptions) { o.InsecureSkipVerify = true }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testDownloadOptions
+			opts.Retries = 0
+			tt.modify(&opts)
+			_, err := downloadTo(t, server.URL, opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DownloadArchive() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDownloadArchiveProxy(t *testing.T) {
+	// The proxy answers itself instead of forwarding the request
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.Write([]byte("archive"))
+	}))
+	defer proxy.Close()
+
+	opts := testDownloadOptions
+	opts.Proxy = strings.TrimPrefix(proxy.URL, "http://")
+	data, err := downloadTo(t, "http://downloads.example.com/tool.tar.gz", opts)
+	if err != nil {
+		t.Fatalf("DownloadArchive() unexpected error: %v", err)
+	}
+	if string(data) != "archive" {
+		t.Errorf("unexpected content %q", data)
+	}
+	if len(proxied) != 1 || proxied[0] != "http://downloads.example.com/tool.tar.gz" {
+		t.Errorf("expected the request to go through the proxy, got %q", proxied)
+	}
+}
+
+func TestParseProxyURL(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"proxy.example.com:3128", "http://proxy.example.com:3128", false},
+		{"https://user:pw@proxy.example.com", "https://user:pw@proxy.example.com", false},
+		{"socks5://127.0.0.1:1080", "socks5://127.0.0.1:1080", false},
+		{"ftp://proxy.example.com", "", true},
+		{"http://", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseProxyURL(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseProxyURL(%s) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+		}
+		if err == nil && got.String() != tt.want {
+			t.Errorf("parseProxyURL(%s) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
@@ -2,17 +2,74 @@ package main
 
 import (
 	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
-// ExtractTarGz extracts a tar.gz archive to the specified directory
-func ExtractTarGz(archivePath string, destDir string) error {
-	fmt.Printf("Extracting archive to %s...\n", destDir)
+// ExtractOptions holds options that affect archive extraction
+type ExtractOptions struct {
+	// Lenient skips entries that fail to extract instead of failing
+	Lenient bool
+	// StripComponents removes leading path components from entry names
+	StripComponents StripComponents
+}
+
+// EntryError describes an archive entry that could not be extracted
+type EntryError struct {
+	Name string
+	Err  error
+}
+
+// ExtractError reports every archive entry that could not be extracted
+type ExtractError struct {
+	Entries []EntryError
+}
+
+func (e *ExtractError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "failed to extract %d archive entries (use -lenient-extract to skip them):", len(e.Entries))
+	for _, entry := range e.Entries {
+		fmt.Fprintf(&b, "\n  %s: %v", entry.Name, entry.Err)
+	}
+	return b.String()
+}
+
+// add records a failed entry
+func (e *ExtractError) add(name string, err error) {
+	e.Entries = append(e.Entries, EntryError{Name: name, Err: err})
+}
+
+// result returns e as an error if any entry failed
+func (e *ExtractError) result() error {
+	if len(e.Entries) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ExtractArchive extracts an archive of the given format to the specified directory
+func ExtractArchive(archivePath string, destDir string, format string, opts ExtractOptions) error {
+	fmt.Printf("Extracting %s archive to %s...\n", format, destDir)
+
+	strip, err := resolveStrip(archivePath, format, opts.StripComponents)
+	if err != nil {
+		return err
+	}
+
+	if format == formatZip {
+		return finishExtraction(extractZip(archivePath, destDir, strip), opts)
+	}
 
 	// Open the archive file
 	file, err := os.Open(archivePath)
@@ -21,15 +78,109 @@ func ExtractTarGz(archivePath string, destDir string) error {
 	}
 	defer file.Close()
 
-	// Create gzip reader
-	gzr, err := gzip.NewReader(file)
+	// Progress is measured by how much of the compressed archive was read
+	var size int64
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+	p := newProgress("Extracting", size)
+	defer p.clear()
+
+	// Failed entries are reported once the whole archive has been read
+	entryErr := readTar(io.TeeReader(file, p), format, func(r io.Reader) error {
+		return extractTar(r, destDir, strip)
+	})
+	var extractErr *ExtractError
+	if entryErr != nil && !errors.As(entryErr, &extractErr) {
+		return entryErr
+	}
+
+	p.clear()
+	return finishExtraction(entryErr, opts)
+}
+
+// readTar decompresses a tar based archive and passes the tar stream to read
+// An *ExtractError from read is returned only after the whole archive was
+// decompressed successfully, any other error stops reading.
+func readTar(r io.Reader, format string, read func(io.Reader) error) error {
+	dr, err := decompress(r, format)
 	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %w", err)
+		return err
+	}
+
+	readErr := read(dr)
+	var extractErr *ExtractError
+	if readErr != nil && !errors.As(readErr, &extractErr) {
+		dr.Close()
+		return readErr
+	}
+
+	// Drain trailing padding so the decompressor verifies the checksum at
+	// the end of the stream and truncated archives are detected
+	if _, err := io.Copy(io.Discard, dr); err != nil {
+		dr.Close()
+		return fmt.Errorf("failed to decompress archive: %w", err)
+	}
+	if err := dr.Close(); err != nil {
+		return fmt.Errorf("failed to decompress archive: %w", err)
+	}
+	return readErr
+}
+
+// finishExtraction reports the outcome of an extraction, skipping failed
+// entries in lenient mode
+func finishExtraction(err error, opts ExtractOptions) error {
+	var extractErr *ExtractError
+	if err == nil {
+		fmt.Println("Extraction completed")
+		return nil
+	}
+	if !errors.As(err, &extractErr) || !opts.Lenient {
+		return err
+	}
+
+	fmt.Printf("Extraction completed, %d entries skipped:\n", len(extractErr.Entries))
+	for _, entry := range extractErr.Entries {
+		fmt.Printf("  [SKIP] %s: %v\n", entry.Name, entry.Err)
 	}
-	defer gzr.Close()
+	return nil
+}
 
+// decompress wraps r with a decompressor for the tar based format
+func decompress(r io.Reader, format string) (io.ReadCloser, error) {
+	switch format {
+	case formatTarGz:
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		return gzr, nil
+	case formatTarBz2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case formatTarXz:
+		xzr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create xz reader: %w", err)
+		}
+		return io.NopCloser(xzr), nil
+	case formatTarZst:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	case formatTar:
+		return io.NopCloser(r), nil
+	}
+	return nil, fmt.Errorf("unsupported archive format %q", format)
+}
+
+// extractTar extracts a tar stream to the specified directory, removing
+// strip leading components from every entry name
+func extractTar(r io.Reader, destDir string, strip int) error {
 	// Create tar reader
-	tr := tar.NewReader(gzr)
+	tr := tar.NewReader(r)
+	failed := &ExtractError{}
 
 	// Extract files
 	for {
@@ -41,36 +192,168 @@ func ExtractTarGz(archivePath string, destDir string) error {
 			return fmt.Errorf("failed to read tar header: %w", err)
 		}
 
+		// Entries above the stripped depth are not extracted
+		name, ok := stripPath(header.Name, strip)
+		if !ok {
+			continue
+		}
+
 		// Construct the full path
-		target := filepath.Join(destDir, header.Name)
+		target := filepath.Join(destDir, name)
 
-		// Security check: ensure the target path is within destDir
-		if !isPathWithinDir(target, destDir) {
+		// Security check: ensure the target path is within destDir, also
+		// when earlier entries created symlinks along the way
+		if !isPathWithinDir(target, destDir) || !resolvesWithinDir(target, destDir) {
 			return fmt.Errorf("invalid file path in archive: %s", header.Name)
 		}
 
-		// Extract based on type
+		// Extract based on type, collecting errors of individual entries
 		switch header.Typeflag {
 		case tar.TypeDir:
 			// Create directory
 			if err := os.MkdirAll(target, os.FileMode(header.Mode)); err != nil {
-				// Continue on error - not fatal
-				continue
+				failed.add(header.Name, err)
 			}
 		case tar.TypeReg:
 			// Extract regular file
 			if err := extractRegularFile(tr, header, target); err != nil {
-				// Continue on error - not fatal
+				failed.add(header.Name, err)
+			}
+		case tar.TypeSymlink:
+			// Security check: the link must not point outside destDir
+			if !isLinkWithinDir(target, header.Linkname, destDir) {
+				return fmt.Errorf("invalid symlink in archive: %s -> %s", header.Name, header.Linkname)
+			}
+			if err := extractSymlink(header.Linkname, target); err != nil {
+				failed.add(header.Name, err)
+			}
+		case tar.TypeLink:
+			// Hard link names are relative to the archive root
+			linkname, ok := stripPath(header.Linkname, strip)
+			if !ok {
+				failed.add(header.Name, fmt.Errorf("hard link target %s is removed by strip_components", header.Linkname))
 				continue
 			}
+			source := filepath.Join(destDir, linkname)
+			if !isPathWithinDir(source, destDir) || !resolvesWithinDir(source, destDir) {
+				return fmt.Errorf("invalid hard link in archive: %s -> %s", header.Name, header.Linkname)
+			}
+			if err := extractHardLink(source, target); err != nil {
+				failed.add(header.Name, err)
+			}
 		default:
-			// Skip other types silently (symlinks, hard links, etc.)
+			// Skip other types silently (devices, fifos, etc.)
 			continue
 		}
 	}
 
-	fmt.Println("Extraction completed")
-	return nil
+	return failed.result()
+}
+
+// extractZip extracts a zip archive to the specified directory, removing
+// strip leading components from every entry name
+func extractZip(archivePath string, destDir string, strip int) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open zip archive: %w", err)
+	}
+	defer zr.Close()
+
+	var size int64
+	for _, f := range zr.File {
+		size += int64(f.UncompressedSize64)
+	}
+	p := newProgress("Extracting", size)
+	defer p.clear()
+
+	failed := &ExtractError{}
+	for _, f := range zr.File {
+		p.add(int64(f.UncompressedSize64))
+
+		// Entries above the stripped depth are not extracted
+		name, ok := stripPath(f.Name, strip)
+		if !ok {
+			continue
+		}
+
+		// Construct the full path
+		target := filepath.Join(destDir, name)
+
+		// Security check: ensure the target path is within destDir
+		if !isPathWithinDir(target, destDir) || !resolvesWithinDir(target, destDir) {
+			return fmt.Errorf("invalid file path in archive: %s", f.Name)
+		}
+
+		// Extract based on type, collecting errors of individual entries
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			// Create directory
+			if err := os.MkdirAll(target, mode.Perm()|0700); err != nil {
+				failed.add(f.Name, err)
+			}
+		case mode.IsRegular():
+			// Extract regular file
+			if err := extractZipFile(f, target); err != nil {
+				failed.add(f.Name, err)
+			}
+		case mode&os.ModeSymlink != 0:
+			// The link target is stored as the file content
+			linkname, err := readZipSymlink(f)
+			if err != nil {
+				failed.add(f.Name, err)
+				continue
+			}
+			// Security check: the link must not point outside destDir
+			if !isLinkWithinDir(target, linkname, destDir) {
+				return fmt.Errorf("invalid symlink in archive: %s -> %s", f.Name, linkname)
+			}
+			if err := extractSymlink(linkname, target); err != nil {
+				failed.add(f.Name, err)
+			}
+		default:
+			// Skip other types silently
+			continue
+		}
+	}
+
+	return failed.result()
+}
+
+// extractZipFile extracts a regular file from a zip archive
+func extractZipFile(f *zip.File, target string) error {
+	// Create directory for the file
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// Never write through a symlink left by an earlier entry
+	if err := removeIfSymlink(target); err != nil {
+		return err
+	}
+
+	// Create the file
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, f.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	// Copy file contents
+	if _, err := io.Copy(file, rc); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return setModeAndTime(target, f.Mode(), f.Modified)
 }
 
 // extractRegularFile extracts a regular file from tar
@@ -80,16 +363,183 @@ func extractRegularFile(tr *tar.Reader, header *tar.Header, target string) error
 		return err
 	}
 
+	// Never write through a symlink left by an earlier entry
+	if err := removeIfSymlink(target); err != nil {
+		return err
+	}
+
 	// Create the file
-	file, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.FileMode(header.Mode))
+	mode := header.FileInfo().Mode()
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, mode.Perm())
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
 	// Copy file contents
-	_, err = io.Copy(file, tr)
-	return err
+	if _, err := io.Copy(file, tr); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return setModeAndTime(target, mode, header.ModTime)
+}
+
+// setModeAndTime applies the permissions and modification time recorded in
+// the archive, which the umask may have changed when the file was created.
+// Setuid, setgid and sticky bits are never applied.
+func setModeAndTime(path string, mode os.FileMode, mtime time.Time) error {
+	if err := os.Chmod(path, mode.Perm()); err != nil {
+		return err
+	}
+	if mtime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(path, mtime, mtime)
+}
+
+// readZipSymlink reads the target of a symlink stored in a zip archive
+func readZipSymlink(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	// Link targets are short, anything larger is not a valid symlink
+	data, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// extractSymlink creates a symlink at target pointing to linkname
+func extractSymlink(linkname, target string) error {
+	// Create directory for the link
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	// Later entries replace earlier ones
+	if err := removeIfExists(target); err != nil {
+		return err
+	}
+
+	return os.Symlink(linkname, target)
+}
+
+// extractHardLink creates a hard link at target to the already extracted source
+func extractHardLink(source, target string) error {
+	// Create directory for the link
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	// Later entries replace earlier ones
+	if err := removeIfExists(target); err != nil {
+		return err
+	}
+
+	return os.Link(source, target)
+}
+
+// removeIfExists removes a non-directory entry at path if there is one
+func removeIfExists(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s exists and is a directory", path)
+	}
+	return os.Remove(path)
+}
+
+// removeIfSymlink removes path if it is a symlink
+func removeIfSymlink(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(path)
+	}
+	return nil
+}
+
+// isLinkWithinDir checks that a symlink created at path pointing to linkname
+// resolves within dir (security check)
+func isLinkWithinDir(path, linkname, dir string) bool {
+	if linkname == "" || filepath.IsAbs(linkname) {
+		return false
+	}
+	// The kernel resolves ".." after following symlinks, so the joined path
+	// must not be cleaned before resolving it
+	resolved := filepath.Dir(path) + string(filepath.Separator) + linkname
+	return isPathWithinDir(resolved, dir) && resolvesWithinDir(resolved, dir)
+}
+
+// resolvesWithinDir follows symlinks in path component by component, as the
+// kernel would when opening it, and checks that the result stays within dir.
+// path must start with dir. Components that do not exist yet are taken
+// literally. (security check)
+func resolvesWithinDir(path, dir string) bool {
+	current := filepath.Clean(dir)
+	rest, ok := strings.CutPrefix(path, current)
+	if !ok || (rest != "" && rest[0] != filepath.Separator) {
+		return false
+	}
+
+	parts := strings.Split(rest, string(filepath.Separator))
+	hops := 0
+
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+			if !isPathWithinDir(current, dir) {
+				return false
+			}
+			continue
+		}
+
+		next := filepath.Join(current, part)
+		info, err := os.Lstat(next)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		// Guard against symlink loops
+		hops++
+		if hops > 255 {
+			return false
+		}
+
+		link, err := os.Readlink(next)
+		if err != nil || filepath.IsAbs(link) {
+			return false
+		}
+
+		// Continue resolving the link target relative to its directory
+		parts = append(strings.Split(link, string(filepath.Separator)), parts...)
+	}
+
+	return isPathWithinDir(current, dir)
 }
 
 // isPathWithinDir checks if a path is within a directory (security check)
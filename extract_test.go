@@ -0,0 +1,344 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// writeTar creates a plain tar archive from headers, using body as the content of regular files
+func writeTar(t *testing.T, headers []*tar.Header, body map[string]string) string {
+	t.Helper()
+	archivePath := filepath.Join(t.TempDir(), "archive.tar")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	defer file.Close()
+
+	tw := tar.NewWriter(file)
+	for _, h := range headers {
+		h.Size = int64(len(body[h.Name]))
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatalf("failed to write header: %v", err)
+		}
+		tw.Write([]byte(body[h.Name]))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close archive: %v", err)
+	}
+	return archivePath
+}
+
+func TestExtractArchiveTar(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "archive.tar")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	tw := tar.NewWriter(file)
+	tw.WriteHeader(&tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "bin/tool", Typeflag: tar.TypeReg, Mode: 0755, Size: 5})
+	tw.Write([]byte("hello"))
+	tw.Close()
+	file.Close()
+
+	destDir := t.TempDir()
+	if err := ExtractArchive(archivePath, destDir, formatTar, ExtractOptions{}); err != nil {
+		t.Fatalf("ExtractArchive() unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(destDir, "bin", "tool"))
+	if err != nil {
+		t.Fatalf("expected extracted file: %v", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("unexpected content %q", data)
+	}
+}
+
+func TestExtractArchivePreservesModeAndTime(t *testing.T) {
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	archivePath := writeTar(t, []*tar.Header{
+		{Name: "bin/tool", Typeflag: tar.TypeReg, Mode: 0750, ModTime: mtime},
+		{Name: "etc/tool.conf", Typeflag: tar.TypeReg, Mode: 04644, ModTime: mtime},
+	}, map[string]string{"bin/tool": "tool", "etc/tool.conf": "conf"})
+
+	destDir := t.TempDir()
+	if err := ExtractArchive(archivePath, destDir, formatTar, ExtractOptions{}); err != nil {
+		t.Fatalf("ExtractArchive() unexpected error: %v", err)
+	}
+
+	for name, want := range map[string]os.FileMode{"bin/tool": 0750, "etc/tool.conf": 0644} {
+		info, err := os.Stat(filepath.Join(destDir, name))
+		if err != nil {
+			t.Fatalf("expected extracted file: %v", err)
+		}
+		if info.Mode() != want {
+			t.Errorf("%s: expected mode %v, got %v", name, want, info.Mode())
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("%s: expected mtime %v, got %v", name, mtime, info.ModTime())
+		}
+	}
+}
+
+func TestExtractArchiveZip(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "archive.zip")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	zw := zip.NewWriter(file)
+	header := &zip.FileHeader{Name: "bin/tool.exe", Method: zip.Deflate}
+	header.SetMode(0755)
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		t.Fatalf("failed to add file: %v", err)
+	}
+	w.Write([]byte("hello"))
+	zw.Close()
+	file.Close()
+
+	destDir := t.TempDir()
+	if err := ExtractArchive(archivePath, destDir, formatZip, ExtractOptions{}); err != nil {
+		t.Fatalf("ExtractArchive() unexpected error: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(destDir, "bin", "tool.exe"))
+	if err != nil {
+		t.Fatalf("expected extracted file: %v", err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("expected mode 0755, got %s", formatMode(info.Mode()))
+	}
+}
+
+func TestExtractArchiveRejectsTraversal(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "archive.tar")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	tw := tar.NewWriter(file)
+	tw.WriteHeader(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1})
+	tw.Write([]byte("x"))
+	tw.Close()
+	file.Close()
+
+	if err := ExtractArchive(archivePath, t.TempDir(), formatTar, ExtractOptions{}); err == nil {
+		t.Error("ExtractArchive() expected error for path traversal, got nil")
+	}
+}
+
+func TestExtractArchiveLinks(t *testing.T) {
+	archivePath := writeTar(t, []*tar.Header{
+		{Name: "lib/cli.js", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "bin/npm", Typeflag: tar.TypeSymlink, Linkname: "../lib/cli.js"},
+		{Name: "bin/cli", Typeflag: tar.TypeLink, Linkname: "lib/cli.js"},
+	}, map[string]string{"lib/cli.js": "cli"})
+
+	destDir := t.TempDir()
+	if err := ExtractArchive(archivePath, destDir, formatTar, ExtractOptions{}); err != nil {
+		t.Fatalf("ExtractArchive() unexpected error: %v", err)
+	}
+
+	link, err := os.Readlink(filepath.Join(destDir, "bin", "npm"))
+	if err != nil {
+		t.Fatalf("expected symlink: %v", err)
+	}
+	if link != "../lib/cli.js" {
+		t.Errorf("unexpected symlink target %q", link)
+	}
+
+	a, err := os.Stat(filepath.Join(destDir, "lib", "cli.js"))
+	if err != nil {
+		t.Fatalf("expected file: %v", err)
+	}
+	b, err := os.Stat(filepath.Join(destDir, "bin", "cli"))
+	if err != nil {
+		t.Fatalf("expected hard link: %v", err)
+	}
+	if !os.SameFile(a, b) {
+		t.Error("expected bin/cli to be a hard link to lib/cli.js")
+	}
+}
+
+func TestExtractArchiveRejectsLinkEscape(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+	}{
+		{
+			name:    "absolute symlink",
+			headers: []*tar.Header{{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/etc"}},
+		},
+		{
+			name:    "relative symlink",
+			headers: []*tar.Header{{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "../.."}},
+		},
+		{
+			name: "symlink through symlink",
+			headers: []*tar.Header{
+				{Name: "self", Typeflag: tar.TypeSymlink, Linkname: "."},
+				{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "self/.."},
+			},
+		},
+		{
+			name: "write through symlink",
+			headers: []*tar.Header{
+				{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+				{Name: "dir/self", Typeflag: tar.TypeSymlink, Linkname: ".."},
+				{Name: "dir/self/self2", Typeflag: tar.TypeSymlink, Linkname: ".."},
+				{Name: "dir/self/self2/evil", Typeflag: tar.TypeReg, Mode: 0644},
+			},
+		},
+		{
+			name:    "hard link outside",
+			headers: []*tar.Header{{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archivePath := writeTar(t, tt.headers, nil)
+			if err := ExtractArchive(archivePath, t.TempDir(), formatTar, ExtractOptions{}); err == nil {
+				t.Error("ExtractArchive() expected error for link escaping the destination, got nil")
+			}
+		})
+	}
+}
+
+func TestExtractArchiveEntryErrors(t *testing.T) {
+	// "conflict/file" cannot be created because "conflict" is a regular file
+	archivePath := writeTar(t, []*tar.Header{
+		{Name: "conflict", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "conflict/file", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "ok", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"conflict": "x", "conflict/file": "y", "ok": "z"})
+
+	err := ExtractArchive(archivePath, t.TempDir(), formatTar, ExtractOptions{})
+	var extractErr *ExtractError
+	if !errors.As(err, &extractErr) {
+		t.Fatalf("ExtractArchive() expected *ExtractError, got %v", err)
+	}
+	if len(extractErr.Entries) != 1 || extractErr.Entries[0].Name != "conflict/file" {
+		t.Errorf("unexpected failed entries: %+v", extractErr.Entries)
+	}
+
+	// Lenient mode skips the failed entry and extracts the rest
+	destDir := t.TempDir()
+	if err := ExtractArchive(archivePath, destDir, formatTar, ExtractOptions{Lenient: true}); err != nil {
+		t.Fatalf("ExtractArchive() unexpected error in lenient mode: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "ok")); err != nil {
+		t.Errorf("expected remaining entries to be extracted: %v", err)
+	}
+}
+
+// compressTar compresses the tar file at path in the given format, using the
+// bzip2 command since Go has no bzip2 encoder. The test is skipped when the
+// command is not installed.
+func compressTar(t *testing.T, path, format string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case formatTarXz:
+		w, err := xz.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("failed to create xz writer: %v", err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatalf("failed to compress archive: %v", err)
+		}
+	case formatTarZst:
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("failed to create zstd writer: %v", err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatalf("failed to compress archive: %v", err)
+		}
+	case formatTarBz2:
+		if _, err := exec.LookPath("bzip2"); err != nil {
+			t.Skip("bzip2 not installed")
+		}
+		out, err := exec.Command("bzip2", "-c", path).Output()
+		if err != nil {
+			t.Fatalf("bzip2 failed: %v", err)
+		}
+		buf.Write(out)
+	}
+
+	compressed := path + "." + format
+	if err := os.WriteFile(compressed, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write compressed archive: %v", err)
+	}
+	return compressed
+}
+
+func TestExtractArchiveCompressed(t *testing.T) {
+	for _, format := range []string{formatTarXz, formatTarZst, formatTarBz2} {
+		t.Run(format, func(t *testing.T) {
+			content := strings.Repeat("tool binary ", 1000)
+			tarPath := writeTar(t, []*tar.Header{
+				{Name: "tool-1.0/", Typeflag: tar.TypeDir, Mode: 0755},
+				{Name: "tool-1.0/bin/tool", Typeflag: tar.TypeReg, Mode: 0755},
+			}, map[string]string{"tool-1.0/bin/tool": content})
+			archivePath := compressTar(t, tarPath, format)
+
+			// Auto strip reads the archive twice, once to list the entries
+			destDir := t.TempDir()
+			opts := ExtractOptions{StripComponents: StripComponents{Auto: true}}
+			if err := ExtractArchive(archivePath, destDir, format, opts); err != nil {
+				t.Fatalf("ExtractArchive() unexpected error: %v", err)
+			}
+			data, err := os.ReadFile(filepath.Join(destDir, "bin", "tool"))
+			if err != nil || string(data) != content {
+				t.Errorf("expected extracted file content, got %d bytes (%v)", len(data), err)
+			}
+
+			// Corrupt the middle of the compressed stream
+			compressed, err := os.ReadFile(archivePath)
+			if err != nil {
+				t.Fatalf("failed to read archive: %v", err)
+			}
+			for i := len(compressed) / 3; i < len(compressed)/2; i++ {
+				compressed[i] ^= 0xff
+			}
+			corrupt := filepath.Join(t.TempDir(), "corrupt")
+			if err := os.WriteFile(corrupt, compressed, 0644); err != nil {
+				t.Fatalf("failed to write archive: %v", err)
+			}
+			if err := ExtractArchive(corrupt, t.TempDir(), format, ExtractOptions{}); err == nil {
+				t.Error("ExtractArchive() expected error for a corrupt stream, got nil")
+			}
+
+			// A truncated stream is detected as well
+			truncated := filepath.Join(t.TempDir(), "truncated")
+			if err := os.WriteFile(truncated, compressed[:len(compressed)/3], 0644); err != nil {
+				t.Fatalf("failed to write archive: %v", err)
+			}
+			if err := ExtractArchive(truncated, t.TempDir(), format, ExtractOptions{}); err == nil {
+				t.Error("ExtractArchive() expected error for a truncated stream, got nil")
+			}
+		})
+	}
+}
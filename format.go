@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Archive formats understood by ExtractArchive
+const (
+	formatTarGz  = "tar.gz"
+	formatTarBz2 = "tar.bz2"
+	formatTarXz  = "tar.xz"
+	formatTarZst = "tar.zst"
+	formatTar    = "tar"
+	formatZip    = "zip"
+)
+
+// formatAliases maps accepted format names and file extensions to a format
+var formatAliases = map[string]string{
+	"tar.gz":  formatTarGz,
+	"tgz":     formatTarGz,
+	"tar.bz2": formatTarBz2,
+	"tbz2":    formatTarBz2,
+	"tbz":     formatTarBz2,
+	"tar.xz":  formatTarXz,
+	"txz":     formatTarXz,
+	"tar.zst": formatTarZst,
+	"tzst":    formatTarZst,
+	"tar":     formatTar,
+	"zip":     formatZip,
+}
+
+// normalizeFormat validates a format name from the mapping file
+func normalizeFormat(format string) (string, error) {
+	if f, ok := formatAliases[strings.ToLower(strings.TrimPrefix(format, "."))]; ok {
+		return f, nil
+	}
+	return "", fmt.Errorf("unsupported archive format %q", format)
+}
+
+// DetectFormat determines the format of an archive from its magic bytes
+// The file name is only used when the content is not recognized
+func DetectFormat(archivePath string, name string) (string, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	// Enough to reach the ustar magic of a tar header
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("failed to read archive: %w", err)
+	}
+	header = header[:n]
+
+	if format := formatFromMagic(header); format != "" {
+		return format, nil
+	}
+
+	if format := formatFromName(name); format != "" {
+		return format, nil
+	}
+
+	return "", fmt.Errorf("unable to detect archive format of %s, set 'format' in the mapping file", name)
+}
+
+// formatFromMagic identifies an archive format from the first bytes of the file
+func formatFromMagic(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return formatTarGz
+	case bytes.HasPrefix(header, []byte("BZh")):
+		return formatTarBz2
+	case bytes.HasPrefix(header, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return formatTarXz
+	case bytes.HasPrefix(header, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return formatTarZst
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return formatZip
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return formatTar
+	}
+	return ""
+}
+
+// formatFromName identifies an archive format from its file extension
+func formatFromName(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{"tar.gz", "tar.bz2", "tar.xz", "tar.zst"} {
+		if strings.HasSuffix(lower, "."+ext) {
+			return formatAliases[ext]
+		}
+	}
+	if i := strings.LastIndex(lower, "."); i >= 0 {
+		return formatAliases[lower[i+1:]]
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tarHeader := make([]byte, 512)
+	copy(tarHeader[257:], "ustar\x0000")
+
+	tests := []struct {
+		name    string
+		data    []byte
+		file    string
+		want    string
+		wantErr bool
+	}{
+		{name: "gzip", data: []byte{0x1f, 0x8b, 0x08, 0x00}, file: "archive", want: formatTarGz},
+		{name: "bzip2", data: []byte("BZh91AY&SY"), file: "archive", want: formatTarBz2},
+		{name: "xz", data: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}, file: "archive", want: formatTarXz},
+		{name: "zstd", data: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, file: "archive", want: formatTarZst},
+		{name: "zip", data: []byte("PK\x03\x04\x14\x00"), file: "archive", want: formatZip},
+		{name: "plain tar", data: tarHeader, file: "archive", want: formatTar},
+		{name: "magic wins over extension", data: []byte{0x1f, 0x8b, 0x08, 0x00}, file: "tool.zip", want: formatTarGz},
+		{name: "extension fallback", data: []byte("unknown"), file: "tool-1.0.0.tar.zst", want: formatTarZst},
+		{name: "undetectable", data: []byte("unknown"), file: "tool", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "archive")
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatalf("failed to write test file: %v", err)
+			}
+
+			got, err := DetectFormat(path, tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DetectFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DetectFormat() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeFormat(t *testing.T) {
+	for input, want := range map[string]string{"tgz": formatTarGz, "TAR.XZ": formatTarXz, ".zip": formatZip} {
+		got, err := normalizeFormat(input)
+		if err != nil {
+			t.Errorf("normalizeFormat(%q) unexpected error: %v", input, err)
+		}
+		if got != want {
+			t.Errorf("normalizeFormat(%q) = %s, want %s", input, got, want)
+		}
+	}
+
+	if _, err := normalizeFormat("rar"); err == nil {
+		t.Error("normalizeFormat() expected error for unsupported format, got nil")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// isGlob reports whether a mapping source contains wildcards
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// validateGlob checks that every segment of a glob pattern is well formed
+func validateGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if segment == "**" {
+			continue
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// globBase returns the leading segments of a pattern that contain no wildcards
+// e.g. "share/doc/**/*.md" has the base "share/doc"
+func globBase(pattern string) string {
+	var base []string
+	for _, segment := range strings.Split(pattern, "/") {
+		if isGlob(segment) {
+			break
+		}
+		base = append(base, segment)
+	}
+	return strings.Join(base, "/")
+}
+
+// matchGlob reports whether a slash separated path matches pattern
+// "**" matches any number of path segments, including none
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchSegments matches path segments against pattern segments
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try every possible number of segments for "**"
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// globArchive returns the paths in the extracted archive matching pattern,
+// relative to extractDir. Entries inside a matched directory are not
+// reported separately since the directory is installed as a whole.
+func globArchive(extractDir, pattern string) ([]string, error) {
+	pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+	var matches []string
+
+	err := filepath.WalkDir(extractDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == extractDir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(extractDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if matchGlob(pattern, rel) {
+			matches = append(matches, rel)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
+
+// expandMappings replaces glob mappings with one mapping per matching path
+// Matches keep their path relative to the glob base below the 'to' directory
+func expandMappings(extractDir string, mappings []Mapping) ([]Mapping, error) {
+	var expanded []Mapping
+	for _, mapping := range mappings {
+		if !isGlob(mapping.From) {
+			expanded = append(expanded, mapping)
+			continue
+		}
+
+		matches, err := globArchive(extractDir, mapping.From)
+		if err != nil {
+			return nil, fmt.Errorf("failed to match %s: %w", mapping.From, err)
+		}
+
+		base := globBase(strings.Trim(filepath.ToSlash(mapping.From), "/"))
+		for _, match := range matches {
+			rel := strings.TrimPrefix(strings.TrimPrefix(match, base), "/")
+			m := mapping
+			m.From = filepath.FromSlash(match)
+			m.To = filepath.Join(mapping.To, filepath.FromSlash(rel))
+			expanded = append(expanded, m)
+		}
+	}
+	return expanded, nil
+}
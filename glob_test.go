@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"bin/*", "bin/limactl", true},
+		{"bin/*", "bin/sub/tool", false},
+		{"bin/*.lima", "bin/docker.lima", true},
+		{"bin/*.lima", "bin/limactl", false},
+		{"share/**/*.md", "share/README.md", true},
+		{"share/**/*.md", "share/doc/a/b/guide.md", true},
+		{"share/**/*.md", "share/doc/guide.txt", false},
+		{"**", "anything/at/all", true},
+		{"lib/**", "lib", true},
+		{"bin/tool-?", "bin/tool-1", true},
+		{"bin/[ab]*", "bin/cli", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExpandMappings(t *testing.T) {
+	extractDir := t.TempDir()
+	for _, name := range []string{"bin/limactl", "bin/docker.lima", "share/doc/a.md", "share/doc/sub/b.md", "share/doc/c.txt"} {
+		path := filepath.Join(extractDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	mappings, err := expandMappings(extractDir, []Mapping{
+		{From: "bin/*", To: "/usr/local/bin/"},
+		{From: "share/**/*.md", To: "/usr/local/share/tool"},
+		{From: "share/doc/c.txt", To: "/etc/tool.txt"},
+	})
+	if err != nil {
+		t.Fatalf("expandMappings() unexpected error: %v", err)
+	}
+
+	want := map[string]string{
+		"bin/docker.lima":    "/usr/local/bin/docker.lima",
+		"bin/limactl":        "/usr/local/bin/limactl",
+		"share/doc/a.md":     "/usr/local/share/tool/doc/a.md",
+		"share/doc/sub/b.md": "/usr/local/share/tool/doc/sub/b.md",
+		"share/doc/c.txt":    "/etc/tool.txt",
+	}
+	if len(mappings) != len(want) {
+		t.Fatalf("expected %d mappings, got %d: %+v", len(want), len(mappings), mappings)
+	}
+	for _, m := range mappings {
+		if want[filepath.ToSlash(m.From)] != m.To {
+			t.Errorf("mapping %s -> %s, want %s", m.From, m.To, want[filepath.ToSlash(m.From)])
+		}
+	}
+}
+
+func TestVerifyArchiveStructureGlob(t *testing.T) {
+	extractDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(extractDir, "bin"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+
+	config := &Config{Mappings: []Mapping{{From: "bin/*", To: "/usr/local/bin/"}}}
+	if err := VerifyArchiveStructure(extractDir, config); err == nil {
+		t.Error("VerifyArchiveStructure() expected error for glob without matches, got nil")
+	}
+
+	if err := os.WriteFile(filepath.Join(extractDir, "bin", "tool"), nil, 0755); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := VerifyArchiveStructure(extractDir, config); err != nil {
+		t.Errorf("VerifyArchiveStructure() unexpected error: %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -9,18 +10,62 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// InstallOptions holds command line options that affect installation
+type InstallOptions struct {
+	KeepTemp       bool
+	SHA256         string
+	Version        string
+	DryRun         bool
+	LenientExtract bool
+	Upgrade        bool // replace a previous installation, removing files the new version no longer ships
+	Overwrite      bool // replace files owned by other packages or not installed by tgzetup
+	Force          bool // remove files modified since installation on upgrade
+	SaveModified   bool // rename files modified since installation on upgrade instead of keeping them
+	Download       DownloadOptions
+}
+
 // Install downloads, extracts, verifies and installs from the given URL
-func Install(url string, config *Config, keepTemp bool) error {
+// The URL may also be a file:// URL, a local path or "-" for standard input.
+func Install(url string, config *Config, opts InstallOptions) error {
+	url, err := normalizeSource(url)
+	if err != nil {
+		return err
+	}
+
+	// An upgrade replaces an existing installation
+	var previous *Receipt
+	if opts.Upgrade {
+		previous, err = LoadReceipt(config.Name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("%s is not installed, use -install instead", config.Name)
+			}
+			return err
+		}
+		fmt.Printf("Upgrading %s (installed from %s)\n", config.Name, previous.Source)
+	}
+
+	// Checksum files and archives are downloaded with the same settings
+	downloadOpts := opts.Download
+	downloadOpts.Auth = config.Auth.forArchive(url)
+
+	// Resolve the expected checksum before downloading anything
+	checksum, err := expectedChecksum(url, config, opts.SHA256, downloadOpts)
+	if err != nil {
+		return err
+	}
+
 	// Create temporary directory
 	tempDir, err := os.MkdirTemp("", "tgzetup-*")
 	if err != nil {
 		return fmt.Errorf("failed to create temp directory: %w", err)
 	}
 
-	// Clean up temp directory unless keepTemp is set
-	if !keepTemp {
+	// Clean up temp directory unless KeepTemp is set
+	if !opts.KeepTemp {
 		defer func() {
 			fmt.Printf("Cleaning up temporary directory...\n")
 			os.RemoveAll(tempDir)
@@ -30,14 +75,56 @@ func Install(url string, config *Config, keepTemp bool) error {
 	}
 
 	// Download archive
-	archivePath := filepath.Join(tempDir, "archive.tar.gz")
-	if err := DownloadArchive(url, archivePath); err != nil {
+	archivePath := filepath.Join(tempDir, "archive")
+	downloadOpts.SHA256 = checksum
+	if opts.DryRun && downloadOpts.Cache != nil {
+		// A dry run may use cached archives but leaves the cache unchanged
+		readOnly := *downloadOpts.Cache
+		readOnly.readOnly = true
+		downloadOpts.Cache = &readOnly
+	}
+	if err := FetchArchive(url, archivePath, downloadOpts); err != nil {
 		return err
 	}
 
+	// Verify checksum
+	if checksum != "" {
+		if err := VerifyChecksum(archivePath, checksum); err != nil {
+			return err
+		}
+	} else {
+		fmt.Println("No checksum configured, skipping checksum verification")
+	}
+
+	// Verify the signature before anything is extracted
+	if config.Signature != nil {
+		if err := VerifySignature(archivePath, url, config.Signature, downloadOpts); err != nil {
+			return err
+		}
+	}
+
+	// Recorded in the receipt so installed versions can be audited
+	archiveDigest, err := fileSHA256(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to compute checksum: %w", err)
+	}
+
+	// Determine archive format, the mapping file overrides detection
+	format := config.Format
+	if format == "" {
+		format, err = DetectFormat(archivePath, archiveName(url))
+		if err != nil {
+			return err
+		}
+	}
+
 	// Extract archive
 	extractDir := filepath.Join(tempDir, "extracted")
-	if err := ExtractTarGz(archivePath, extractDir); err != nil {
+	extractOpts := ExtractOptions{
+		Lenient:         opts.LenientExtract,
+		StripComponents: config.StripComponents,
+	}
+	if err := ExtractArchive(archivePath, extractDir, format, extractOpts); err != nil {
 		return err
 	}
 
@@ -46,15 +133,122 @@ func Install(url string, config *Config, keepTemp bool) error {
 		return err
 	}
 
-	// Install files
-	fmt.Println("Installing files...")
-	for _, mapping := range config.Mappings {
-		if err := installMapping(extractDir, mapping); err != nil {
+	// Install files, recording everything written in the receipt
+	receipt := &Receipt{
+		Name:          config.Name,
+		Version:       opts.Version,
+		Source:        sourceLabel(url),
+		ArchiveSHA256: archiveDigest,
+		InstalledAt:   time.Now().UTC(),
+	}
+
+	// Stage every mapping next to its destination before touching existing files
+	// Resolve glob mappings to the paths they match
+	mappings, err := expandMappings(extractDir, config.Mappings)
+	if err != nil {
+		return err
+	}
+
+	links := newLinkResolver(extractDir, mappings)
+	tx := newTransaction(receipt, links)
+	if opts.DryRun {
+		stageDir := filepath.Join(tempDir, "staged")
+		if err := os.MkdirAll(stageDir, 0755); err != nil {
+			return fmt.Errorf("failed to create staging directory: %w", err)
+		}
+		tx = newDryRunTransaction(receipt, links, stageDir)
+	}
+
+	fmt.Println("Staging files...")
+	for _, mapping := range mappings {
+		if err := installMapping(extractDir, mapping, tx); err != nil {
+			tx.rollback()
 			return fmt.Errorf("failed to install %s: %w", mapping.From, err)
 		}
 	}
 
-	if keepTemp {
+	// Remove what the new version no longer ships, as part of the same transaction
+	var preserved *uninstaller
+	if previous != nil {
+		preserved, err = stageUpgrade(previous, tx, UninstallOptions{
+			DryRun:       opts.DryRun,
+			Force:        opts.Force,
+			SaveModified: opts.SaveModified,
+		})
+		if err != nil {
+			tx.rollback()
+			return err
+		}
+	}
+
+	// Refuse to replace files of other packages and files tgzetup did not install
+	conflicts, err := checkConflicts(config.Name, tx, opts.Overwrite)
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+
+	if opts.DryRun {
+		if err := tx.printPlan(); err != nil {
+			return err
+		}
+		if preserved != nil {
+			preserved.printPreserved()
+		}
+		return nil
+	}
+
+	// Move everything into place
+	fmt.Println("Installing files...")
+	if err := tx.commit(); err != nil {
+		tx.rollback()
+		return fmt.Errorf("failed to install files: %w", err)
+	}
+
+	// Keep track of files from a previous installation of the same package
+	if prev, err := LoadReceipt(config.Name); err == nil {
+		receipt.mergePrevious(prev)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("  Warning: ignoring previous install receipt: %v\n", err)
+	}
+
+	// An installation without a receipt could not be uninstalled, so roll back if saving fails
+	if err := receipt.Save(); err != nil {
+		tx.rollback()
+		return err
+	}
+	tx.cleanup()
+
+	// Overwritten files now belong to this package
+	releaseConflicts(conflicts)
+
+	for _, f := range tx.staged {
+		fmt.Printf("  Installed %s\n", f.target)
+	}
+	for _, f := range tx.removals {
+		if f.backup == "" {
+			continue
+		}
+		if f.save != "" {
+			fmt.Printf("  Saved %s as %s (modified since installation)\n", f.target, f.save)
+		} else {
+			fmt.Printf("  Removed %s\n", f.target)
+		}
+	}
+	if preserved != nil {
+		preserved.printPreserved()
+	}
+
+	// Directories of the previous version can only go once their backups are gone
+	if previous != nil && pruneStaleDirectories(tx) {
+		if err := receipt.Save(); err != nil {
+			fmt.Printf("  Warning: %v\n", err)
+		}
+	}
+
+	fmt.Printf("Install receipt saved to %s\n", receiptPath(receipt.Name))
+
+	if opts.KeepTemp {
 		fmt.Printf("\nTemporary directory kept at: %s\n", tempDir)
 	}
 
@@ -62,89 +256,93 @@ func Install(url string, config *Config, keepTemp bool) error {
 }
 
 // installMapping installs a single mapping entry
-func installMapping(extractDir string, mapping Mapping) error {
+func installMapping(extractDir string, mapping Mapping, tx *transaction) error {
 	sourcePath := filepath.Join(extractDir, mapping.From)
 	targetPath := expandPath(mapping.To)
 
-	sourceInfo, err := os.Stat(sourcePath)
+	sourceInfo, err := os.Lstat(sourcePath)
 	if err != nil {
 		return fmt.Errorf("failed to stat source: %w", err)
 	}
 
+	// Mode and ownership requested by the mapping
+	attrs, err := mappingAttrs(mapping)
+	if err != nil {
+		return err
+	}
+
+	if sourceInfo.Mode()&os.ModeSymlink != 0 {
+		if err := installSymlink(sourcePath, targetPath, attrs, tx); err != nil {
+			return err
+		}
+		fmt.Printf("  Staged %s (symlink)\n", targetPath)
+		return nil
+	}
+
 	if sourceInfo.IsDir() {
-		return installDirectory(sourcePath, targetPath)
+		return installDirectory(sourcePath, targetPath, attrs, tx)
 	}
 
-	return installFile(sourcePath, targetPath)
+	return installFile(sourcePath, targetPath, attrs, tx)
 }
 
-// installFile installs a single file
-func installFile(sourcePath, targetPath string) error {
+// installFile stages a single file
+func installFile(sourcePath, targetPath string, attrs fileAttrs, tx *transaction) error {
 	// Handle gzipped files
 	if filepath.Ext(sourcePath) == ".gz" {
-		if err := extractGzipFile(sourcePath, targetPath); err != nil {
-			return fmt.Errorf("failed to extract gzip file: %w", err)
-		}
-		if err := os.Chmod(targetPath, 0755); err != nil {
-			return fmt.Errorf("failed to set executable permission: %w", err)
-		}
-		// Fix ownership if needed
-		if err := fixOwnership(targetPath); err != nil {
-			return fmt.Errorf("failed to fix ownership: %w", err)
+		err := tx.stageFile(targetPath, func(staging string) error {
+			if err := extractGzipFile(sourcePath, staging); err != nil {
+				return fmt.Errorf("failed to extract gzip file: %w", err)
+			}
+			if err := os.Chmod(staging, gzipFileMode(sourcePath, targetPath)); err != nil {
+				return fmt.Errorf("failed to set permissions: %w", err)
+			}
+			return tx.applyAttrs(staging, attrs)
+		})
+		if err != nil {
+			return err
 		}
-		fmt.Printf("  Installed %s (extracted from gzip)\n", targetPath)
+		fmt.Printf("  Staged %s (extracted from gzip)\n", targetPath)
 		return nil
 	}
 
 	// Handle regular files
-	if err := copyFile(sourcePath, targetPath); err != nil {
-		return fmt.Errorf("failed to copy file: %w", err)
-	}
-
-	// Make binary files executable
-	if isBinary(targetPath) {
-		if err := os.Chmod(targetPath, 0755); err != nil {
-			return fmt.Errorf("failed to set executable permission: %w", err)
+	err := tx.stageFile(targetPath, func(staging string) error {
+		// The copy keeps the permissions and modification time from the archive
+		if err := copyOrLink(sourcePath, staging, tx); err != nil {
+			return fmt.Errorf("failed to copy file: %w", err)
 		}
+		return tx.applyAttrs(staging, attrs)
+	})
+	if err != nil {
+		return err
 	}
 
-	// Fix ownership if needed
-	if err := fixOwnership(targetPath); err != nil {
-		return fmt.Errorf("failed to fix ownership: %w", err)
-	}
-
-	fmt.Printf("  Installed %s\n", targetPath)
+	fmt.Printf("  Staged %s\n", targetPath)
 	return nil
 }
 
-// installDirectory installs a directory
-func installDirectory(sourcePath, targetPath string) error {
-	if err := copyDirectory(sourcePath, targetPath); err != nil {
+// installDirectory stages a directory
+func installDirectory(sourcePath, targetPath string, attrs fileAttrs, tx *transaction) error {
+	if err := copyDirectory(sourcePath, targetPath, attrs, tx); err != nil {
 		return fmt.Errorf("failed to copy directory: %w", err)
 	}
 
-	// Fix ownership recursively if needed
-	if err := fixOwnershipRecursive(targetPath); err != nil {
-		return fmt.Errorf("failed to fix ownership: %w", err)
+	// Fix ownership recursively if needed, ownership set by the mapping takes precedence
+	// A dry run shows the changes in the plan instead
+	if attrs.uid == -1 && attrs.gid == -1 && !tx.dryRun {
+		if err := fixOwnershipRecursive(targetPath); err != nil {
+			return fmt.Errorf("failed to fix ownership: %w", err)
+		}
 	}
 
-	fmt.Printf("  Installed %s (directory)\n", targetPath)
+	fmt.Printf("  Staged %s (directory)\n", targetPath)
 	return nil
 }
 
-// copyFile copies a single file from source to destination
+// copyFile copies a single file from source to destination, preserving its
+// permissions and modification time
 func copyFile(src, dst string) error {
-	// Create destination directory if it doesn't exist
-	dstDir := filepath.Dir(dst)
-	if err := os.MkdirAll(dstDir, 0755); err != nil {
-		return err
-	}
-
-	// Fix ownership of parent directories if they were just created
-	if err := fixOwnershipPath(dstDir); err != nil {
-		return err
-	}
-
 	// Open source file
 	sourceFile, err := os.Open(src)
 	if err != nil {
@@ -152,28 +350,41 @@ func copyFile(src, dst string) error {
 	}
 	defer sourceFile.Close()
 
+	info, err := sourceFile.Stat()
+	if err != nil {
+		return err
+	}
+
 	// Create destination file
-	destFile, err := os.Create(dst)
+	destFile, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
 	if err != nil {
 		return err
 	}
-	defer destFile.Close()
 
 	// Copy contents
-	_, err = io.Copy(destFile, sourceFile)
-	return err
+	if _, err := io.Copy(destFile, sourceFile); err != nil {
+		destFile.Close()
+		return err
+	}
+	if err := destFile.Close(); err != nil {
+		return err
+	}
+
+	return setModeAndTime(dst, info.Mode(), info.ModTime())
 }
 
-// copyDirectory recursively copies a directory
-func copyDirectory(src, dst string) error {
+// copyDirectory recursively stages a directory, recording created directories in the receipt
+func copyDirectory(src, dst string, attrs fileAttrs, tx *transaction) error {
 	// Create destination directory
-	if err := os.MkdirAll(dst, 0755); err != nil {
+	if err := mkdirRecorded(dst, 0755, attrs, tx); err != nil {
 		return err
 	}
 
 	// Fix ownership of the destination directory itself if it's in home
-	if err := fixOwnership(dst); err != nil {
-		return err
+	if !tx.dryRun {
+		if err := fixOwnership(dst); err != nil {
+			return err
+		}
 	}
 
 	// Walk through source directory
@@ -191,22 +402,61 @@ func copyDirectory(src, dst string) error {
 
 		if info.IsDir() {
 			// Create directory
-			if err := os.MkdirAll(dstPath, info.Mode()); err != nil {
+			if err := mkdirRecorded(dstPath, info.Mode(), attrs, tx); err != nil {
 				return err
 			}
 			// Fix ownership immediately after creating
+			if tx.dryRun {
+				return nil
+			}
 			return fixOwnership(dstPath)
 		}
 
-		// Copy file
-		if err := copyFile(path, dstPath); err != nil {
-			return err
+		// Preserve symlinks that still resolve at the destination
+		if info.Mode()&os.ModeSymlink != 0 {
+			return installSymlink(path, dstPath, attrs, tx)
 		}
-		// Fix ownership of copied file
-		return fixOwnership(dstPath)
+
+		// Copy file, keeping hard links within the archive
+		return tx.stageFile(dstPath, func(staging string) error {
+			if err := copyOrLink(path, staging, tx); err != nil {
+				return err
+			}
+			return tx.applyAttrs(staging, attrs)
+		})
 	})
 }
 
+// mkdirRecorded creates a directory and records it in the receipt if it did not exist before
+// The mapping's directory attributes are applied when the transaction commits,
+// also to directories of the mapping that already exist
+func mkdirRecorded(path string, mode os.FileMode, attrs fileAttrs, tx *transaction) error {
+	if info, err := os.Stat(path); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s exists and is not a directory", path)
+		}
+		tx.useExistingDir(path)
+		if attrs.hasDirAttrs() {
+			tx.setDirAttrs(path, attrs, info)
+		}
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := tx.mkdirAll(path, mode); err != nil {
+		return err
+	}
+	if attrs.hasDirAttrs() {
+		tx.setDirAttrs(path, attrs, nil)
+	}
+	if attrs.dirMode.Set {
+		mode = attrs.dirMode.Perm
+	}
+	tx.receipt.addDirectory(path, mode)
+	return nil
+}
+
 // extractGzipFile extracts a gzip compressed file
 func extractGzipFile(src, dst string) error {
 	// Create destination directory if it doesn't exist
@@ -233,17 +483,82 @@ func extractGzipFile(src, dst string) error {
 	if err != nil {
 		return err
 	}
-	defer dstFile.Close()
 
 	// Copy uncompressed content
-	_, err = io.Copy(dstFile, gz)
-	return err
+	if _, err := io.Copy(dstFile, gz); err != nil {
+		dstFile.Close()
+		return err
+	}
+	if err := dstFile.Close(); err != nil {
+		return err
+	}
+
+	// Keep the modification time from the gzip header, or of the .gz file
+	mtime := gz.ModTime
+	if mtime.IsZero() {
+		info, err := gzFile.Stat()
+		if err != nil {
+			return err
+		}
+		mtime = info.ModTime()
+	}
+	return os.Chtimes(dst, mtime, mtime)
+}
+
+// gzipFileMode returns the permissions for a file extracted from a .gz file
+// gzip does not store a mode, so the archive mode of the .gz file is used,
+// falling back to the path heuristic when it is not executable
+func gzipFileMode(sourcePath, targetPath string) os.FileMode {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(sourcePath); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if mode&0111 == 0 && isBinary(targetPath) {
+		mode = 0755
+	}
+	return mode
 }
 
 // isBinary checks if the file path indicates it's a binary executable
 func isBinary(path string) bool {
-	// Check if file is in /usr/local/bin
-	return filepath.Dir(path) == "/usr/local/bin"
+	// Check if file is in a bin directory, e.g. /usr/local/bin or ~/.local/bin
+	dir := filepath.Base(filepath.Dir(path))
+	return dir == "bin" || dir == "sbin"
+}
+
+// lookupIDs returns the UID and GID of the named user
+func lookupIDs(name string) (int, int, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to lookup user %s: %w", name, err)
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse UID: %w", err)
+	}
+
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse GID: %w", err)
+	}
+
+	return uid, gid, nil
+}
+
+// sudoOwnerOf returns the UID and GID fixOwnership assigns to path
+// The boolean is false when the ownership of path is left unchanged
+func sudoOwnerOf(path string) (int, int, bool, error) {
+	sudoUser := os.Getenv("SUDO_USER")
+	if sudoUser == "" || !isInHomeDirectory(path) {
+		return 0, 0, false, nil
+	}
+
+	uid, gid, err := lookupIDs(sudoUser)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return uid, gid, true, nil
 }
 
 // fixOwnership fixes file ownership when running with sudo
@@ -260,23 +575,13 @@ func fixOwnership(path string) error {
 	}
 
 	// Get the original user's UID and GID
-	u, err := user.Lookup(sudoUser)
-	if err != nil {
-		return fmt.Errorf("failed to lookup user %s: %w", sudoUser, err)
-	}
-
-	uid, err := strconv.Atoi(u.Uid)
+	uid, gid, err := lookupIDs(sudoUser)
 	if err != nil {
-		return fmt.Errorf("failed to parse UID: %w", err)
-	}
-
-	gid, err := strconv.Atoi(u.Gid)
-	if err != nil {
-		return fmt.Errorf("failed to parse GID: %w", err)
+		return err
 	}
 
-	// Change ownership
-	return os.Chown(path, uid, gid)
+	// Change ownership, without following symlinks
+	return os.Lchown(path, uid, gid)
 }
 
 // fixOwnershipRecursive fixes ownership recursively for directories
@@ -293,19 +598,9 @@ func fixOwnershipRecursive(path string) error {
 	}
 
 	// Get the original user's UID and GID
-	u, err := user.Lookup(sudoUser)
+	uid, gid, err := lookupIDs(sudoUser)
 	if err != nil {
-		return fmt.Errorf("failed to lookup user %s: %w", sudoUser, err)
-	}
-
-	uid, err := strconv.Atoi(u.Uid)
-	if err != nil {
-		return fmt.Errorf("failed to parse UID: %w", err)
-	}
-
-	gid, err := strconv.Atoi(u.Gid)
-	if err != nil {
-		return fmt.Errorf("failed to parse GID: %w", err)
+		return err
 	}
 
 	// Walk through directory and change ownership
@@ -313,7 +608,7 @@ func fixOwnershipRecursive(path string) error {
 		if err != nil {
 			return err
 		}
-		return os.Chown(p, uid, gid)
+		return os.Lchown(p, uid, gid)
 	})
 }
 
@@ -331,19 +626,9 @@ func fixOwnershipPath(path string) error {
 	}
 
 	// Get the original user's UID and GID
-	u, err := user.Lookup(sudoUser)
-	if err != nil {
-		return fmt.Errorf("failed to lookup user %s: %w", sudoUser, err)
-	}
-
-	uid, err := strconv.Atoi(u.Uid)
-	if err != nil {
-		return fmt.Errorf("failed to parse UID: %w", err)
-	}
-
-	gid, err := strconv.Atoi(u.Gid)
+	uid, gid, err := lookupIDs(sudoUser)
 	if err != nil {
-		return fmt.Errorf("failed to parse GID: %w", err)
+		return err
 	}
 
 	// Fix ownership of the path and parent directories up to home
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestIsBinary(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/usr/local/bin/tool", true},
+		{"/home/user/.local/bin/tool", true},
+		{"/opt/tool/bin/tool", true},
+		{"/usr/sbin/toold", true},
+		{"/etc/tool/tool.conf", false},
+		{"/usr/local/share/tool/bin.txt", false},
+	}
+
+	for _, tt := range tests {
+		if got := isBinary(tt.path); got != tt.want {
+			t.Errorf("isBinary(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCopyFilePreservesModeAndTime(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, []byte("tool"), 0750); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	if err := os.Chmod(src, 0750); err != nil {
+		t.Fatalf("failed to chmod source: %v", err)
+	}
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatalf("failed to set source mtime: %v", err)
+	}
+
+	dst := filepath.Join(dir, "dst")
+	if err := copyFile(src, dst); err != nil {
+		t.Fatalf("copyFile() unexpected error: %v", err)
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatalf("expected copied file: %v", err)
+	}
+	if info.Mode().Perm() != 0750 {
+		t.Errorf("expected mode 0750, got %v", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("expected mtime %v, got %v", mtime, info.ModTime())
+	}
+}
+
+func TestGzipFileMode(t *testing.T) {
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "agent.gz")
+	plain := filepath.Join(dir, "data.gz")
+	if err := os.WriteFile(executable, nil, 0755); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	if err := os.WriteFile(plain, nil, 0644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+
+	tests := []struct {
+		source string
+		target string
+		want   os.FileMode
+	}{
+		{executable, "/opt/tool/agent", 0755},
+		{plain, "/usr/local/bin/agent", 0755},
+		{plain, "/usr/local/share/tool/data", 0644},
+	}
+
+	for _, tt := range tests {
+		if got := gzipFileMode(tt.source, tt.target); got != tt.want {
+			t.Errorf("gzipFileMode(%s, %s) = %v, want %v", filepath.Base(tt.source), tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestDryRunDirectoryMappingUnderSudo(t *testing.T) {
+	current, err := user.Current()
+	if err != nil || current.HomeDir == "" {
+		t.Skip("current user has no home directory")
+	}
+	// Ownership of paths in the home directory of SUDO_USER is fixed
+	t.Setenv("SUDO_USER", current.Username)
+
+	extractDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(extractDir, "doc", "sub"), 0755); err != nil {
+		t.Fatalf("failed to create test directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(extractDir, "doc", "sub", "README"), []byte("doc"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	// Never created, a dry run must not touch it
+	target := filepath.Join(current.HomeDir, ".tgzetup-dry-run-test", "doc")
+	if _, err := os.Lstat(filepath.Dir(target)); !os.IsNotExist(err) {
+		t.Skipf("%s already exists", filepath.Dir(target))
+	}
+
+	mapping := Mapping{From: "doc", To: target}
+	tx := newDryRunTransaction(&Receipt{}, newLinkResolver(extractDir, []Mapping{mapping}), t.TempDir())
+	if err := installMapping(extractDir, mapping, tx); err != nil {
+		t.Fatalf("installMapping() unexpected error: %v", err)
+	}
+	if err := tx.printPlan(); err != nil {
+		t.Errorf("printPlan() unexpected error: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Dir(target)); !os.IsNotExist(err) {
+		t.Errorf("dry run must not create %s", filepath.Dir(target))
+	}
+
+	// Existing directories of the mapping are listed for the plan, not changed
+	existing := t.TempDir()
+	mapping = Mapping{From: "doc", To: existing}
+	tx = newDryRunTransaction(&Receipt{}, newLinkResolver(extractDir, []Mapping{mapping}), t.TempDir())
+	if err := installMapping(extractDir, mapping, tx); err != nil {
+		t.Fatalf("installMapping() unexpected error: %v", err)
+	}
+	if len(tx.existingDirs) != 1 || tx.existingDirs[0] != existing {
+		t.Errorf("expected existing directory %s to be planned, got %v", existing, tx.existingDirs)
+	}
+}
+
+// writeTarGz creates a .tar.gz archive with the given files, compressing
+// the content of files ending in .gz once more
+func writeTarGz(t *testing.T, files map[string]string) string {
+	t.Helper()
+	archivePath := filepath.Join(t.TempDir(), "tool.tar.gz")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	defer file.Close()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		content := []byte(files[name])
+		if filepath.Ext(name) == ".gz" {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write(content)
+			zw.Close()
+			content = buf.Bytes()
+		}
+		header := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(content))}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("failed to write header: %v", err)
+		}
+		tw.Write(content)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close archive: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to close archive: %v", err)
+	}
+	return archivePath
+}
+
+func TestInstall(t *testing.T) {
+	t.Setenv("TGZETUP_STATE_DIR", t.TempDir())
+	t.Setenv("SUDO_USER", "")
+
+	archive := writeTarGz(t, map[string]string{
+		"bin/tool":             "tool v1",
+		"share/man/tool.1.gz":  "manual",
+		"share/doc/README":     "readme",
+		"share/doc/sub/NOTICE": "notice",
+	})
+	dest := t.TempDir()
+	config := &Config{
+		Name: "tool",
+		Mappings: []Mapping{
+			{From: "bin/tool", To: filepath.Join(dest, "bin", "tool")},
+			{From: "share/man/tool.1.gz", To: filepath.Join(dest, "share", "man", "man1", "tool.1")},
+			{From: "share/doc", To: filepath.Join(dest, "share", "doc", "tool")},
+		},
+	}
+
+	if err := Install(archive, config, InstallOptions{Version: "1.0"}); err != nil {
+		t.Fatalf("Install() unexpected error: %v", err)
+	}
+
+	files := map[string]string{
+		filepath.Join(dest, "bin", "tool"):                           "tool v1",
+		filepath.Join(dest, "share", "man", "man1", "tool.1"):        "manual",
+		filepath.Join(dest, "share", "doc", "tool", "README"):        "readme",
+		filepath.Join(dest, "share", "doc", "tool", "sub", "NOTICE"): "notice",
+	}
+	for path, want := range files {
+		if data, err := os.ReadFile(path); err != nil || string(data) != want {
+			t.Errorf("%s: expected %q, got %q (%v)", path, want, data, err)
+		}
+	}
+
+	receipt, err := LoadReceipt("tool")
+	if err != nil {
+		t.Fatalf("LoadReceipt() unexpected error: %v", err)
+	}
+	digest, err := fileSHA256(archive)
+	if err != nil {
+		t.Fatalf("failed to hash archive: %v", err)
+	}
+	if receipt.Version != "1.0" || receipt.Source != archive || receipt.ArchiveSHA256 != digest {
+		t.Errorf("unexpected receipt metadata: %+v", receipt)
+	}
+	for path := range files {
+		if !receipt.hasFile(path) {
+			t.Errorf("expected %s to be recorded", path)
+		}
+	}
+	for _, dir := range []string{
+		filepath.Join(dest, "bin"),
+		filepath.Join(dest, "share", "man", "man1"),
+		filepath.Join(dest, "share", "doc", "tool", "sub"),
+	} {
+		if !receipt.hasDirectory(dir) {
+			t.Errorf("expected created directory %s to be recorded", dir)
+		}
+	}
+	if receipt.hasDirectory(dest) {
+		t.Errorf("expected pre-existing directory %s not to be recorded", dest)
+	}
+}
+
+func TestInstallRollsBackFailedMapping(t *testing.T) {
+	t.Setenv("TGZETUP_STATE_DIR", t.TempDir())
+	t.Setenv("SUDO_USER", "")
+
+	archive := writeTarGz(t, map[string]string{
+		"bin/tool":         "tool",
+		"share/doc/README": "readme",
+	})
+	dest := t.TempDir()
+	blocker := filepath.Join(dest, "share")
+	if err := os.WriteFile(blocker, []byte("not a directory"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	config := &Config{
+		Name: "tool",
+		Mappings: []Mapping{
+			{From: "bin/tool", To: filepath.Join(dest, "bin", "tool")},
+			{From: "share/doc", To: filepath.Join(blocker, "doc")},
+		},
+	}
+
+	if err := Install(archive, config, InstallOptions{}); err == nil {
+		t.Fatal("Install() expected error, got nil")
+	}
+
+	// The first mapping was staged and is removed again, including its directory
+	if _, err := os.Lstat(filepath.Join(dest, "bin")); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be rolled back, got %v", filepath.Join(dest, "bin"), err)
+	}
+	if _, err := LoadReceipt("tool"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no install receipt, got %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// linkResolver decides how symlinks and hard links from the archive are installed
+type linkResolver struct {
+	extractDir string
+	mappings   []Mapping
+	installed  map[int64][]installedFile // staged regular files by size
+}
+
+// installedFile is a regular file from the archive that has been staged
+type installedFile struct {
+	source  string // path within the extract directory
+	info    os.FileInfo
+	staging string
+}
+
+// newLinkResolver creates a resolver for the archive extracted to extractDir
+func newLinkResolver(extractDir string, mappings []Mapping) *linkResolver {
+	return &linkResolver{
+		extractDir: extractDir,
+		mappings:   mappings,
+		installed:  make(map[int64][]installedFile),
+	}
+}
+
+// destinationOf returns where the mappings install the archive path rel
+func (l *linkResolver) destinationOf(rel string) (string, bool) {
+	rel = filepath.Clean(rel)
+	for _, mapping := range l.mappings {
+		from := filepath.Clean(mapping.From)
+		if rel == from {
+			return expandPath(mapping.To), true
+		}
+		if strings.HasPrefix(rel, from+string(filepath.Separator)) {
+			return filepath.Join(expandPath(mapping.To), rel[len(from)+1:]), true
+		}
+	}
+	return "", false
+}
+
+// symlinkTarget reads the symlink at sourcePath and reports whether it can be
+// preserved at targetPath, which is the case when the link still resolves to
+// the installed copy of the file it points to in the archive
+func (l *linkResolver) symlinkTarget(sourcePath, targetPath string) (string, bool, error) {
+	link, err := os.Readlink(sourcePath)
+	if err != nil {
+		return "", false, err
+	}
+
+	rel, err := filepath.Rel(l.extractDir, sourcePath)
+	if err != nil {
+		return "", false, err
+	}
+
+	// Extraction guarantees links are relative and stay inside the archive
+	dest, ok := l.destinationOf(filepath.Join(filepath.Dir(rel), link))
+	if !ok {
+		return link, false, nil
+	}
+
+	resolved := filepath.Join(filepath.Dir(targetPath), link)
+	return link, resolved == filepath.Clean(dest), nil
+}
+
+// resolve returns the path within the extract directory that the symlink at
+// sourcePath eventually points to
+func (l *linkResolver) resolve(sourcePath string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(sourcePath)
+	if err != nil {
+		return "", err
+	}
+
+	// The extract directory itself may be reached through a symlink, e.g. /tmp
+	realDir, err := filepath.EvalSymlinks(l.extractDir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(realDir, resolved)
+	if err != nil || !isPathWithinDir(resolved, realDir) {
+		return "", fmt.Errorf("symlink %s points outside the archive", sourcePath)
+	}
+	return filepath.Join(l.extractDir, rel), nil
+}
+
+// hardLinkSource returns the staging path of a previously staged file that
+// is a hard link to sourcePath in the archive, or an empty string if there is
+// none. The same archive path installed by several mappings is copied, so
+// the mode and ownership of one target never apply to the other.
+func (l *linkResolver) hardLinkSource(sourcePath string, info os.FileInfo) string {
+	for _, f := range l.installed[info.Size()] {
+		if f.source != sourcePath && os.SameFile(f.info, info) {
+			return f.staging
+		}
+	}
+	return ""
+}
+
+// addInstalled remembers a staged regular file for hard link detection
+func (l *linkResolver) addInstalled(sourcePath string, info os.FileInfo, staging string) {
+	l.installed[info.Size()] = append(l.installed[info.Size()], installedFile{source: sourcePath, info: info, staging: staging})
+}
+
+// copyOrLink stages a regular file, hard linking it to an earlier staged file
+// when both are hard links to each other in the archive
+func copyOrLink(sourcePath, staging string, tx *transaction) error {
+	sourcePath = filepath.Clean(sourcePath)
+	info, err := os.Lstat(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	if existing := tx.links.hardLinkSource(sourcePath, info); existing != "" {
+		if err := os.Link(existing, staging); err == nil {
+			return nil
+		}
+		// Fall back to copying, e.g. across file systems
+	}
+
+	if err := copyFile(sourcePath, staging); err != nil {
+		return err
+	}
+	tx.links.addInstalled(sourcePath, info, staging)
+	return nil
+}
+
+// installSymlink stages the symlink at sourcePath for targetPath. The link is
+// preserved when it resolves to the installed copy of its target, otherwise
+// the file or directory it points to is installed in its place.
+func installSymlink(sourcePath, targetPath string, attrs fileAttrs, tx *transaction) error {
+	link, preserve, err := tx.links.symlinkTarget(sourcePath, targetPath)
+	if err != nil {
+		return fmt.Errorf("failed to read symlink: %w", err)
+	}
+
+	info, err := os.Stat(sourcePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if !preserve && err != nil {
+		fmt.Printf("  Warning: %s is a dangling symlink, installing it as is\n", targetPath)
+		preserve = true
+	}
+
+	if preserve {
+		return tx.stageFile(targetPath, func(staging string) error {
+			if err := os.Symlink(link, staging); err != nil {
+				return err
+			}
+			return tx.applyAttrs(staging, attrs)
+		})
+	}
+
+	// Install what the link points to instead
+	resolved, err := tx.links.resolve(sourcePath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return copyDirectory(resolved, targetPath, attrs, tx)
+	}
+	return tx.stageFile(targetPath, func(staging string) error {
+		if err := copyOrLink(resolved, staging, tx); err != nil {
+			return err
+		}
+		return tx.applyAttrs(staging, attrs)
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkResolverSymlinkTarget(t *testing.T) {
+	extractDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(extractDir, "bin"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	source := filepath.Join(extractDir, "bin", "npm")
+	if err := os.Symlink("../lib/npm/cli.js", source); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		mappings []Mapping
+		target   string
+		want     bool
+	}{
+		{
+			name:     "tree installed together",
+			mappings: []Mapping{{From: "bin", To: "/opt/node/bin"}, {From: "lib", To: "/opt/node/lib"}},
+			target:   "/opt/node/bin/npm",
+			want:     true,
+		},
+		{
+			name:     "target installed elsewhere",
+			mappings: []Mapping{{From: "bin/npm", To: "/usr/local/bin/npm"}, {From: "lib", To: "/opt/node/lib"}},
+			target:   "/usr/local/bin/npm",
+			want:     false,
+		},
+		{
+			name:     "target not installed",
+			mappings: []Mapping{{From: "bin/npm", To: "/usr/local/bin/npm"}},
+			target:   "/usr/local/bin/npm",
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLinkResolver(extractDir, tt.mappings)
+			link, preserve, err := l.symlinkTarget(source, tt.target)
+			if err != nil {
+				t.Fatalf("symlinkTarget() unexpected error: %v", err)
+			}
+			if link != "../lib/npm/cli.js" {
+				t.Errorf("symlinkTarget() link = %s", link)
+			}
+			if preserve != tt.want {
+				t.Errorf("symlinkTarget() preserve = %v, want %v", preserve, tt.want)
+			}
+		})
+	}
+}
+
+func TestCopyOrLinkSameSourceTwice(t *testing.T) {
+	extractDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(extractDir, "etc"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	conf := filepath.Join(extractDir, "etc", "tool.conf")
+	if err := os.WriteFile(conf, []byte("conf"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	// A hard link in the archive
+	if err := os.Link(conf, filepath.Join(extractDir, "etc", "link.conf")); err != nil {
+		t.Fatalf("failed to create hard link: %v", err)
+	}
+
+	dest := t.TempDir()
+	a := filepath.Join(dest, "a", "tool.conf")
+	b := filepath.Join(dest, "b", "tool.conf")
+	c := filepath.Join(dest, "a", "link.conf")
+	mappings := []Mapping{
+		{From: "etc/tool.conf", To: a},
+		{From: "etc/tool.conf", To: b, Mode: PermMode{Perm: 0600, Set: true}},
+		{From: "etc/link.conf", To: c},
+	}
+
+	tx := newTransaction(&Receipt{}, newLinkResolver(extractDir, mappings))
+	for _, mapping := range mappings {
+		if err := installMapping(extractDir, mapping, tx); err != nil {
+			t.Fatalf("installMapping() unexpected error: %v", err)
+		}
+	}
+	if err := tx.commit(); err != nil {
+		t.Fatalf("commit() unexpected error: %v", err)
+	}
+
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	infoC, errC := os.Stat(c)
+	if errA != nil || errB != nil || errC != nil {
+		t.Fatalf("expected all targets to be installed: %v %v %v", errA, errB, errC)
+	}
+
+	// The same archive path is copied, the mode of one target must not change the other
+	if os.SameFile(infoA, infoB) {
+		t.Errorf("expected %s and %s to be separate files", a, b)
+	}
+	if infoA.Mode().Perm() != 0644 || infoB.Mode().Perm() != 0600 {
+		t.Errorf("expected modes 0644 and 0600, got %v and %v", infoA.Mode().Perm(), infoB.Mode().Perm())
+	}
+
+	// Hard links within the archive are kept
+	if !os.SameFile(infoA, infoC) {
+		t.Errorf("expected %s and %s to stay hard linked", a, c)
+	}
+}
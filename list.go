@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// List prints every installed package as a table, or as JSON for scripts
+func List(asJSON bool) error {
+	return listPackages(os.Stdout, asJSON)
+}
+
+// listPackages writes the installed packages to out
+func listPackages(out io.Writer, asJSON bool) error {
+	receipts, err := ListReceipts()
+	if err != nil {
+		return fmt.Errorf("failed to read install receipts: %w", err)
+	}
+
+	if asJSON {
+		return printJSON(out, receipts)
+	}
+
+	if len(receipts) == 0 {
+		fmt.Fprintln(out, "No packages installed")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tFILES\tINSTALLED\tSOURCE")
+	for _, r := range receipts {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n",
+			r.Name, orDash(r.Version), len(r.Files), formatTime(r.InstalledAt), r.Source)
+	}
+	return w.Flush()
+}
+
+// Info prints the install receipt of a single package
+func Info(name string, asJSON bool) error {
+	return showPackage(os.Stdout, name, asJSON)
+}
+
+// showPackage writes the install receipt of the named package to out
+func showPackage(out io.Writer, name string, asJSON bool) error {
+	// The name becomes part of the receipt path
+	if err := validatePackageName(name); err != nil {
+		return err
+	}
+	receipt, err := LoadReceipt(name)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		return printJSON(out, receipt)
+	}
+
+	fmt.Fprintf(out, "Name:        %s\n", receipt.Name)
+	fmt.Fprintf(out, "Version:     %s\n", orDash(receipt.Version))
+	fmt.Fprintf(out, "Source:      %s\n", receipt.Source)
+	if receipt.ArchiveSHA256 != "" {
+		fmt.Fprintf(out, "Archive:     sha256:%s\n", receipt.ArchiveSHA256)
+	}
+	fmt.Fprintf(out, "Installed:   %s\n", formatTime(receipt.InstalledAt))
+
+	fmt.Fprintf(out, "Files:       %d\n", len(receipt.Files))
+	for _, f := range receipt.Files {
+		if f.Link != "" {
+			fmt.Fprintf(out, "  %s -> %s\n", f.Path, f.Link)
+			continue
+		}
+		fmt.Fprintf(out, "  %s (%s, %d bytes)\n", f.Path, f.Mode, f.Size)
+	}
+
+	fmt.Fprintf(out, "Directories: %d\n", len(receipt.Directories))
+	for _, d := range receipt.Directories {
+		fmt.Fprintf(out, "  %s (%s)\n", d.Path, d.Mode)
+	}
+	return nil
+}
+
+// printJSON writes v to out as indented JSON
+func printJSON(out io.Writer, v any) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// formatTime formats an installation time in local time
+func formatTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// orDash returns s, or "-" when it is empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// saveTestReceipts writes receipts for two packages to a temporary state directory
+func saveTestReceipts(t *testing.T) []*Receipt {
+	t.Helper()
+	t.Setenv("TGZETUP_STATE_DIR", t.TempDir())
+
+	installedAt := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	receipts := []*Receipt{
+		{
+			Name:          "alpha",
+			Version:       "1.2.0",
+			Source:        "https://example.com/alpha-1.2.0.tar.gz",
+			ArchiveSHA256: strings.Repeat("ab", 32),
+			InstalledAt:   installedAt,
+			Files: []FileRecord{
+				{Path: "/opt/alpha/bin/alpha", Size: 1024, Mode: "0755", SHA256: strings.Repeat("01", 32)},
+				{Path: "/usr/local/bin/alpha", Size: 20, Mode: "0777", Link: "/opt/alpha/bin/alpha"},
+			},
+			Directories: []DirectoryRecord{{Path: "/opt/alpha", Mode: "0755"}},
+		},
+		{
+			Name:        "beta",
+			Source:      "(stdin)",
+			InstalledAt: installedAt.Add(time.Hour),
+			Files:       []FileRecord{{Path: "/usr/local/bin/beta", Size: 10, Mode: "0755"}},
+			Directories: []DirectoryRecord{},
+		},
+	}
+	for _, r := range receipts {
+		if err := r.Save(); err != nil {
+			t.Fatalf("Save() unexpected error: %v", err)
+		}
+	}
+	return receipts
+}
+
+func TestListPackages(t *testing.T) {
+	receipts := saveTestReceipts(t)
+
+	var out bytes.Buffer
+	if err := listPackages(&out, false); err != nil {
+		t.Fatalf("listPackages() unexpected error: %v", err)
+	}
+	installed := formatTime(receipts[0].InstalledAt)
+	want := "NAME   VERSION  FILES  INSTALLED            SOURCE\n" +
+		"alpha  1.2.0    2      " + installed + "  https://example.com/alpha-1.2.0.tar.gz\n" +
+		"beta   -        1      " + formatTime(receipts[1].InstalledAt) + "  (stdin)\n"
+	if out.String() != want {
+		t.Errorf("listPackages() table =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := listPackages(&out, true); err != nil {
+		t.Fatalf("listPackages() unexpected error: %v", err)
+	}
+	var decoded []*Receipt
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("listPackages() printed invalid JSON: %v\n%s", err, out.String())
+	}
+	if !reflect.DeepEqual(decoded, receipts) {
+		t.Errorf("listPackages() JSON = %+v, want %+v", decoded, receipts)
+	}
+	for _, key := range []string{`"name": "alpha"`, `"archive_sha256"`, `"installed_at": "2024-05-01T12:30:00Z"`, `"link": "/opt/alpha/bin/alpha"`} {
+		if !strings.Contains(out.String(), key) {
+			t.Errorf("expected JSON output to contain %s", key)
+		}
+	}
+}
+
+func TestListPackagesEmpty(t *testing.T) {
+	t.Setenv("TGZETUP_STATE_DIR", t.TempDir())
+
+	var out bytes.Buffer
+	if err := listPackages(&out, false); err != nil {
+		t.Fatalf("listPackages() unexpected error: %v", err)
+	}
+	if out.String() != "No packages installed\n" {
+		t.Errorf("listPackages() = %q, want %q", out.String(), "No packages installed\n")
+	}
+
+	// Scripts get an empty array rather than null
+	out.Reset()
+	if err := listPackages(&out, true); err != nil {
+		t.Fatalf("listPackages() unexpected error: %v", err)
+	}
+	if out.String() != "[]\n" {
+		t.Errorf("listPackages() JSON = %q, want %q", out.String(), "[]\n")
+	}
+}
+
+func TestShowPackage(t *testing.T) {
+	receipts := saveTestReceipts(t)
+
+	var out bytes.Buffer
+	if err := showPackage(&out, "alpha", false); err != nil {
+		t.Fatalf("showPackage() unexpected error: %v", err)
+	}
+	want := "Name:        alpha\n" +
+		"Version:     1.2.0\n" +
+		"Source:      https://example.com/alpha-1.2.0.tar.gz\n" +
+		"Archive:     sha256:" + strings.Repeat("ab", 32) + "\n" +
+		"Installed:   " + formatTime(receipts[0].InstalledAt) + "\n" +
+		"Files:       2\n" +
+		"  /opt/alpha/bin/alpha (0755, 1024 bytes)\n" +
+		"  /usr/local/bin/alpha -> /opt/alpha/bin/alpha\n" +
+		"Directories: 1\n" +
+		"  /opt/alpha (0755)\n"
+	if out.String() != want {
+		t.Errorf("showPackage() =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := showPackage(&out, "beta", false); err != nil {
+		t.Fatalf("showPackage() unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "Version:     -\n") || strings.Contains(out.String(), "Archive:") {
+		t.Errorf("expected no version and no archive checksum, got\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := showPackage(&out, "alpha", true); err != nil {
+		t.Fatalf("showPackage() unexpected error: %v", err)
+	}
+	var decoded Receipt
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("showPackage() printed invalid JSON: %v\n%s", err, out.String())
+	}
+	if !reflect.DeepEqual(&decoded, receipts[0]) {
+		t.Errorf("showPackage() JSON = %+v, want %+v", decoded, receipts[0])
+	}
+
+	if err := showPackage(&out, "missing", false); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("showPackage() for an unknown package: expected not exist error, got %v", err)
+	}
+
+	// Names must not reach outside the receipt directory
+	outside := filepath.Join(filepath.Dir(receiptPath("alpha")), "..", "outside")
+	if err := os.WriteFile(outside+".json", []byte(`{"name": "outside"}`), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	for _, name := range []string{"../outside", "a/b", ""} {
+		out.Reset()
+		if err := showPackage(&out, name, false); err == nil || errors.Is(err, os.ErrNotExist) {
+			t.Errorf("showPackage(%q) expected invalid name error, got %v", name, err)
+		}
+		if out.Len() > 0 {
+			t.Errorf("showPackage(%q) printed %q", name, out.String())
+		}
+	}
+}
@@ -4,23 +4,95 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 )
 
 const version = "0.1.0"
 
+// urlFlag is the -install or -upgrade flag. The URL may be omitted when the
+// mapping file has a url template.
+type urlFlag struct {
+	set bool
+	url string
+}
+
+func (f *urlFlag) String() string { return f.url }
+
+func (f *urlFlag) Set(value string) error {
+	f.set = true
+	f.url = value
+	return nil
+}
+
+// allowBareURLFlags rewrites a bare -install or -upgrade that is followed by
+// another flag or nothing at all to -install= or -upgrade=, since the flag
+// package would otherwise take the next flag as its URL
+func allowBareURLFlags(args []string) []string {
+	result := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(result, args[i:]...)
+		}
+		switch arg {
+		case "-install", "--install", "-upgrade", "--upgrade":
+			if i+1 == len(args) || (len(args[i+1]) > 1 && strings.HasPrefix(args[i+1], "-")) {
+				arg += "="
+			}
+		}
+		result = append(result, arg)
+	}
+	return result
+}
+
 func main() {
-	var installURL string
+	var install urlFlag
+	var upgrade urlFlag
+	var versionOf string
 	var uninstall bool
+	var force bool
+	var saveModified bool
+	var overwrite bool
 	var keepTemp bool
 	var showVersion bool
 	var mappingFile string
+	var sha256sum string
+	var packageName string
+	var dryRun bool
+	var lenientExtract bool
+	var list bool
+	var infoName string
+	var asJSON bool
+	downloadOpts := defaultDownloadOptions
+	var cacheCommand string
+	var cacheDir string
+	var noCache bool
 
-	flag.StringVar(&installURL, "install", "", "URL of tar.gz archive to install")
+	flag.Var(&install, "install", "URL, local path or - (stdin) of archive to install (optional when the mapping file has a url template)")
+	flag.Var(&upgrade, "upgrade", "URL of a new version to replace an installed package with (optional with a url template)")
+	flag.StringVar(&versionOf, "version-of", "", "Version to install, filled into the url template of the mapping file")
 	flag.BoolVar(&uninstall, "uninstall", false, "Uninstall")
+	flag.BoolVar(&force, "force", false, "Remove files modified since installation when uninstalling or upgrading")
+	flag.BoolVar(&saveModified, "save-modified", false, "Rename files modified since installation to <file>.tgzetup-save when uninstalling or upgrading")
+	flag.BoolVar(&overwrite, "overwrite", false, "Replace files owned by other packages or not installed by tgzetup")
+	flag.BoolVar(&list, "list", false, "List installed packages")
+	flag.StringVar(&infoName, "info", "", "Show details of an installed package")
+	flag.BoolVar(&asJSON, "json", false, "Print -list and -info output as JSON")
+	flag.BoolVar(&dryRun, "dry-run", false, "Show what would be installed or removed without changing anything")
+	flag.BoolVar(&lenientExtract, "lenient-extract", false, "Skip archive entries that fail to extract instead of failing")
+	flag.DurationVar(&downloadOpts.Timeout, "timeout", downloadOpts.Timeout, "Timeout for connecting and for receiving data during downloads")
+	flag.IntVar(&downloadOpts.Retries, "retries", downloadOpts.Retries, "Number of times to retry a failed download")
+	flag.StringVar(&downloadOpts.Proxy, "proxy", "", "Proxy for downloads, overrides HTTPS_PROXY, HTTP_PROXY and NO_PROXY")
+	flag.StringVar(&downloadOpts.CAFile, "ca-file", "", "PEM file with additional CA certificates to trust for downloads")
+	flag.BoolVar(&downloadOpts.InsecureSkipVerify, "insecure-skip-verify", false, "Disable TLS certificate verification for downloads (insecure)")
+	flag.BoolVar(&noCache, "no-cache", false, "Always download the archive instead of using the download cache")
+	flag.StringVar(&cacheDir, "cache-dir", "", "Directory for cached downloads")
+	flag.StringVar(&cacheCommand, "cache", "", "Manage the download cache: list or prune")
 	flag.BoolVar(&keepTemp, "keep-temp", false, "Keep temporary directory after installation")
 	flag.BoolVar(&showVersion, "version", false, "Show version")
-	flag.StringVar(&mappingFile, "mapping", "", "Path to mapping configuration file (required)")
-	flag.Parse()
+	flag.StringVar(&mappingFile, "mapping", "", "Path to mapping configuration file (required for -install)")
+	flag.StringVar(&packageName, "name", "", "Package name (defaults to the mapping file name)")
+	flag.StringVar(&sha256sum, "sha256", "", "Expected SHA-256 checksum of the archive")
+	flag.CommandLine.Parse(allowBareURLFlags(os.Args[1:]))
 
 	if showVersion {
 		fmt.Printf("tgzetup %s\n", version)
@@ -28,43 +100,137 @@ func main() {
 	}
 
 	// Check mutually exclusive options
-	if installURL != "" && uninstall {
-		fmt.Fprintf(os.Stderr, "Error: -install and -uninstall cannot be used together\n")
+	actions := 0
+	for _, set := range []bool{install.set, upgrade.set, uninstall, list, infoName != "", cacheCommand != ""} {
+		if set {
+			actions++
+		}
+	}
+	if actions > 1 {
+		fmt.Fprintf(os.Stderr, "Error: -install, -upgrade, -uninstall, -list, -info and -cache cannot be used together\n")
+		os.Exit(1)
+	}
+
+	if force && saveModified {
+		fmt.Fprintf(os.Stderr, "Error: -force and -save-modified cannot be used together\n")
 		os.Exit(1)
 	}
 
 	// Require at least one action
-	if installURL == "" && !uninstall {
+	if actions == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	// Require mapping file
-	if mappingFile == "" {
+	// Querying installed packages and managing the cache needs no mapping file
+	if list || infoName != "" || cacheCommand != "" {
+		var err error
+		switch {
+		case list:
+			err = List(asJSON)
+		case infoName != "":
+			err = Info(infoName, asJSON)
+		case cacheCommand == "list":
+			err = NewCache(cacheDir).List()
+		case cacheCommand == "prune":
+			err = NewCache(cacheDir).Prune()
+		default:
+			err = fmt.Errorf("unknown cache command %q, expected list or prune", cacheCommand)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Require mapping file, uninstall can work from the package name alone
+	if mappingFile == "" && !(uninstall && packageName != "") {
 		fmt.Fprintf(os.Stderr, "Error: -mapping option is required\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
 	// Load mapping configuration
-	config, err := LoadMapping(mappingFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading mapping file: %v\n", err)
+	var config *Config
+	if mappingFile != "" {
+		var err error
+		config, err = LoadMapping(mappingFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading mapping file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Determine the package name
+	if packageName == "" {
+		packageName = config.Name
+	}
+	if err := validatePackageName(packageName); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+	if config != nil {
+		config.Name = packageName
+	}
 
 	// Execute the requested action
 	var actionErr error
 	if uninstall {
-		actionErr = Uninstall(config)
-		if actionErr == nil {
+		opts := UninstallOptions{
+			DryRun:       dryRun,
+			Force:        force,
+			SaveModified: saveModified,
+		}
+		actionErr = Uninstall(packageName, config, opts)
+		if actionErr == nil && !dryRun {
 			fmt.Println("Uninstallation completed.")
 		}
 	} else {
-		actionErr = Install(installURL, config, keepTemp)
-		if actionErr == nil {
-			fmt.Println("Installation completed successfully.")
+		if !noCache {
+			downloadOpts.Cache = NewCache(cacheDir)
+		}
+		if downloadOpts.InsecureSkipVerify {
+			fmt.Fprintln(os.Stderr, "WARNING: TLS certificate verification is disabled by -insecure-skip-verify.")
+			fmt.Fprintln(os.Stderr, "WARNING: Downloads can be intercepted or altered, configure a checksum to detect this.")
 		}
+		opts := InstallOptions{
+			KeepTemp:       keepTemp,
+			SHA256:         sha256sum,
+			Version:        versionOf,
+			DryRun:         dryRun,
+			LenientExtract: lenientExtract,
+			Upgrade:        upgrade.set,
+			Overwrite:      overwrite,
+			Force:          force,
+			SaveModified:   saveModified,
+			Download:       downloadOpts,
+		}
+		source := install
+		if upgrade.set {
+			source = upgrade
+		}
+		installURL, err := config.ResolveURL(source.url, versionOf)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if source.url == "" {
+			fmt.Printf("Resolved URL: %s\n", redactURL(installURL))
+		}
+
+		actionErr = Install(installURL, config, opts)
+		if actionErr == nil && !dryRun {
+			if upgrade.set {
+				fmt.Println("Upgrade completed successfully.")
+			} else {
+				fmt.Println("Installation completed successfully.")
+			}
+		}
+	}
+
+	if actionErr == nil && dryRun {
+		fmt.Println("Dry run completed, no changes were made.")
 	}
 
 	if actionErr != nil {
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllowBareURLFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "with URL",
+			args: []string{"-install", "https://example.com/tool.tar.gz", "-mapping", "tool.yaml"},
+			want: []string{"-install", "https://example.com/tool.tar.gz", "-mapping", "tool.yaml"},
+		},
+		{
+			name: "followed by flag",
+			args: []string{"-install", "-mapping", "tool.yaml", "-version-of", "1.2.1"},
+			want: []string{"-install=", "-mapping", "tool.yaml", "-version-of", "1.2.1"},
+		},
+		{
+			name: "last argument",
+			args: []string{"-mapping", "tool.yaml", "-install"},
+			want: []string{"-mapping", "tool.yaml", "-install="},
+		},
+		{
+			name: "upgrade followed by flag",
+			args: []string{"-upgrade", "-mapping", "tool.yaml"},
+			want: []string{"-upgrade=", "-mapping", "tool.yaml"},
+		},
+		{
+			name: "single dash value",
+			args: []string{"-install", "-", "-mapping", "tool.yaml"},
+			want: []string{"-install", "-", "-mapping", "tool.yaml"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := allowBareURLFlags(tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("allowBareURLFlags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,19 +3,36 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Mapping represents a single file/directory mapping
 type Mapping struct {
-	From string `yaml:"from"`
-	To   string `yaml:"to"`
+	From     string   `yaml:"from"`
+	To       string   `yaml:"to"`
+	Mode     PermMode `yaml:"mode"`
+	FileMode PermMode `yaml:"file_mode"`
+	DirMode  PermMode `yaml:"dir_mode"`
+	Owner    string   `yaml:"owner"`
+	Group    string   `yaml:"group"`
 }
 
 // Config represents the complete mapping configuration
 type Config struct {
-	Mappings []Mapping `yaml:"mappings"`
+	Name            string            `yaml:"name"`
+	URL             string            `yaml:"url"`
+	OSAliases       map[string]string `yaml:"os_aliases"`
+	ArchAliases     map[string]string `yaml:"arch_aliases"`
+	Format          string            `yaml:"format"`
+	StripComponents StripComponents   `yaml:"strip_components"`
+	Checksum        string            `yaml:"checksum"`
+	ChecksumURL     string            `yaml:"checksum_url"`
+	Signature       *Signature        `yaml:"signature"`
+	Auth            *Auth             `yaml:"auth"`
+	Mappings        []Mapping         `yaml:"mappings"`
 }
 
 // LoadMapping loads and parses the mapping configuration file
@@ -43,7 +60,91 @@ func LoadMapping(path string) (*Config, error) {
 		if mapping.To == "" {
 			return nil, fmt.Errorf("mapping %d: 'to' field is empty", i)
 		}
+		for _, text := range []string{mapping.From, mapping.To} {
+			if _, err := parseURLTemplate(text); err != nil {
+				return nil, fmt.Errorf("mapping %d: invalid template: %w", i, err)
+			}
+		}
+		if isGlob(mapping.From) {
+			if err := validateGlob(mapping.From); err != nil {
+				return nil, fmt.Errorf("mapping %d: %w", i, err)
+			}
+		}
+	}
+
+	// Default the package name to the mapping file name
+	if config.Name == "" {
+		config.Name = packageNameFromPath(path)
+	}
+	if err := validatePackageName(config.Name); err != nil {
+		return nil, err
+	}
+
+	// Validate url templates
+	if config.URL != "" {
+		if _, err := parseURLTemplate(config.URL); err != nil {
+			return nil, fmt.Errorf("invalid url template: %w", err)
+		}
+	}
+	if config.ChecksumURL != "" {
+		if _, err := parseURLTemplate(config.ChecksumURL); err != nil {
+			return nil, fmt.Errorf("invalid checksum_url template: %w", err)
+		}
+	}
+
+	// Validate archive format override
+	if config.Format != "" {
+		format, err := normalizeFormat(config.Format)
+		if err != nil {
+			return nil, err
+		}
+		config.Format = format
+	}
+
+	// Validate checksum settings
+	if config.Checksum != "" {
+		if _, err := normalizeChecksum(config.Checksum); err != nil {
+			return nil, err
+		}
+		if config.ChecksumURL != "" {
+			return nil, fmt.Errorf("'checksum' and 'checksum_url' cannot be used together")
+		}
+	}
+
+	// Validate signature settings
+	if config.Signature != nil {
+		if config.Signature.URL != "" {
+			if _, err := parseURLTemplate(config.Signature.URL); err != nil {
+				return nil, fmt.Errorf("invalid signature url template: %w", err)
+			}
+		}
+		if err := config.Signature.load(path); err != nil {
+			return nil, fmt.Errorf("invalid signature: %w", err)
+		}
+	}
+
+	// Validate authentication settings
+	if config.Auth != nil {
+		if err := config.Auth.validate(); err != nil {
+			return nil, fmt.Errorf("invalid auth: %w", err)
+		}
 	}
 
 	return &config, nil
 }
+
+// packageNameFromPath derives a package name from a mapping file path
+// e.g. "examples/lima/lima-mapping.yaml" becomes "lima"
+func packageNameFromPath(path string) string {
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	return strings.TrimSuffix(name, "-mapping")
+}
+
+// validatePackageName checks that a package name can be used as a file name
+func validatePackageName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid package name %q", name)
+	}
+	return nil
+}
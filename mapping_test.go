@@ -31,6 +31,9 @@ func TestLoadMapping(t *testing.T) {
 				if config.Mappings[0].To != "/usr/local/bin/limactl" {
 					t.Errorf("expected first mapping to '/usr/local/bin/limactl', got %s", config.Mappings[0].To)
 				}
+				if config.Name != "test" {
+					t.Errorf("expected name derived from file name 'test', got %s", config.Name)
+				}
 			},
 		},
 		{
@@ -50,6 +53,180 @@ func TestLoadMapping(t *testing.T) {
   - from: "bin/limactl"`,
 			wantErr: true,
 		},
+		{
+			name: "explicit name",
+			yaml: `name: "lima"
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			wantErr: false,
+			check: func(t *testing.T, config *Config) {
+				if config.Name != "lima" {
+					t.Errorf("expected name 'lima', got %s", config.Name)
+				}
+			},
+		},
+		{
+			name: "invalid name",
+			yaml: `name: "../lima"
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			wantErr: true,
+		},
+		{
+			name: "format override",
+			yaml: `format: "tgz"
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			wantErr: false,
+			check: func(t *testing.T, config *Config) {
+				if config.Format != formatTarGz {
+					t.Errorf("expected format %s, got %s", formatTarGz, config.Format)
+				}
+			},
+		},
+		{
+			name: "unsupported format",
+			yaml: `format: "rar"
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			wantErr: true,
+		},
+		{
+			name: "strip components count",
+			yaml: `strip_components: 1
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			wantErr: false,
+			check: func(t *testing.T, config *Config) {
+				if config.StripComponents != (StripComponents{Count: 1}) {
+					t.Errorf("expected strip_components 1, got %+v", config.StripComponents)
+				}
+			},
+		},
+		{
+			name: "strip components auto",
+			yaml: `strip_components: auto
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			wantErr: false,
+			check: func(t *testing.T, config *Config) {
+				if !config.StripComponents.Auto {
+					t.Errorf("expected strip_components auto, got %+v", config.StripComponents)
+				}
+			},
+		},
+		{
+			name: "invalid strip components",
+			yaml: `strip_components: -1
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			wantErr: true,
+		},
+		{
+			name: "invalid url template",
+			yaml: `url: "https://example.com/tool-{{.Version.tar.gz"
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			wantErr: true,
+		},
+		{
+			name: "auth",
+			yaml: `auth:
+  token: "${ARTIFACTORY_TOKEN}"
+  hosts: ["artifactory.example.com"]
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			check: func(t *testing.T, config *Config) {
+				if config.Auth == nil || config.Auth.Token != "${ARTIFACTORY_TOKEN}" || len(config.Auth.Hosts) != 1 {
+					t.Errorf("unexpected auth %+v", config.Auth)
+				}
+			},
+		},
+		{
+			name: "minisign signature",
+			yaml: `signature:
+  type: minisign
+  url: "tool-{{.Version}}.tar.gz.minisig"
+  public_key: "RWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y7GFO3"
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			check: func(t *testing.T, config *Config) {
+				if config.Signature == nil || config.Signature.Type != "minisign" || config.Signature.key == "" {
+					t.Errorf("unexpected signature %+v", config.Signature)
+				}
+			},
+		},
+		{
+			name: "signature with invalid key",
+			yaml: `signature:
+  type: minisign
+  public_key: "not a key"
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			wantErr: true,
+		},
+		{
+			name: "unknown signature type",
+			yaml: `signature:
+  type: pgp
+  public_key: "key"
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			wantErr: true,
+		},
+		{
+			name: "auth with token and password",
+			yaml: `auth:
+  token: "${ARTIFACTORY_TOKEN}"
+  username: "deploy"
+  password: "${ARTIFACTORY_PASSWORD}"
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			wantErr: true,
+		},
+		{
+			name: "checksum",
+			yaml: `checksum: "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			wantErr: false,
+			check: func(t *testing.T, config *Config) {
+				if config.Checksum == "" {
+					t.Error("expected checksum to be set")
+				}
+			},
+		},
+		{
+			name: "invalid checksum",
+			yaml: `checksum: "abc"
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			wantErr: true,
+		},
+		{
+			name: "checksum and checksum_url",
+			yaml: `checksum: "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+checksum_url: "SHA256SUMS"
+mappings:
+  - from: "bin/limactl"
+    to: "/usr/local/bin/limactl"`,
+			wantErr: true,
+		},
 		{
 			name:    "invalid yaml",
 			yaml:    `mappings: [invalid`,
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// printPlan prints what committing the transaction would change on disk
+func (tx *transaction) printPlan() error {
+	fmt.Println("Installation plan (dry run, nothing will be changed):")
+
+	for _, dir := range tx.createdDirs {
+		fmt.Printf("  [mkdir]     %s\n", dir)
+		if err := tx.printDirChanges(dir); err != nil {
+			return err
+		}
+	}
+	for _, dir := range tx.existingDirs {
+		if err := tx.printDirChanges(dir); err != nil {
+			return err
+		}
+	}
+
+	created, overwritten := 0, 0
+	for _, f := range tx.staged {
+		newInfo, err := os.Lstat(f.staging)
+		if err != nil {
+			return err
+		}
+
+		oldInfo, err := os.Lstat(f.target)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		// Symlinks are shown by their target
+		if newInfo.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(f.staging)
+			if err != nil {
+				return err
+			}
+			if oldInfo == nil {
+				created++
+				fmt.Printf("  [create]    %s (symlink -> %s)\n", f.target, link)
+			} else {
+				overwritten++
+				fmt.Printf("  [overwrite] %s (symlink -> %s)\n", f.target, link)
+				printSave(f)
+			}
+			if err := printOwner(f); err != nil {
+				return err
+			}
+			continue
+		}
+
+		newSum, err := fileSHA256(f.staging)
+		if err != nil {
+			return err
+		}
+
+		// The mapping's mode is not applied to the staged copy in a dry run
+		mode := newInfo.Mode()
+		if f.attrs.fileMode.Set {
+			mode = f.attrs.fileMode.Perm
+		}
+
+		if oldInfo == nil {
+			created++
+			fmt.Printf("  [create]    %s (%d bytes, mode %s, sha256 %s)\n",
+				f.target, newInfo.Size(), formatMode(mode), shortSum(newSum))
+		} else {
+			oldSum, err := fileSHA256(f.target)
+			if err != nil {
+				return err
+			}
+			overwritten++
+			note := ""
+			if oldSum == newSum {
+				note = ", content unchanged"
+			}
+			fmt.Printf("  [overwrite] %s (%d -> %d bytes, sha256 %s -> %s%s)\n",
+				f.target, oldInfo.Size(), newInfo.Size(), shortSum(oldSum), shortSum(newSum), note)
+			printSave(f)
+			if oldInfo.Mode().Perm() != mode.Perm() {
+				fmt.Printf("  [chmod]     %s (%s -> %s)\n",
+					f.target, formatMode(oldInfo.Mode()), formatMode(mode))
+			}
+		}
+
+		if err := printOwner(f); err != nil {
+			return err
+		}
+	}
+
+	// Files and directories an upgrade no longer ships
+	removed := 0
+	for _, f := range tx.removals {
+		if info, err := os.Lstat(f.target); err != nil || info.IsDir() {
+			continue
+		}
+		removed++
+		if f.save != "" {
+			fmt.Printf("  [save]      %s -> %s (not in new version, modified since installation)\n", f.target, f.save)
+			continue
+		}
+		fmt.Printf("  [remove]    %s (not in new version)\n", f.target)
+	}
+	for _, dir := range tx.staleDirs {
+		fmt.Printf("  [rmdir]     %s (if empty)\n", dir)
+	}
+
+	fmt.Printf("\n%d file(s) to create, %d file(s) to overwrite, ", created, overwritten)
+	if len(tx.removals) > 0 {
+		fmt.Printf("%d file(s) to remove, ", removed)
+	}
+	fmt.Printf("%d directory(ies) to create\n", len(tx.createdDirs))
+	return nil
+}
+
+// printDirChanges prints the mode and ownership changes to a directory of the
+// installation, set by the mapping or by fixOwnership
+func (tx *transaction) printDirChanges(dir string) error {
+	if attrs, ok := tx.attrsOfDir(dir); ok {
+		printDirAttrs(dir, attrs)
+		if attrs.uid != -1 || attrs.gid != -1 {
+			return nil
+		}
+	}
+	return printChown(dir)
+}
+
+// printDirAttrs prints the mode and ownership a mapping sets on a created directory
+func printDirAttrs(dir string, attrs fileAttrs) {
+	if attrs.dirMode.Set {
+		fmt.Printf("  [chmod]     %s (%s)\n", dir, formatMode(attrs.dirMode.Perm))
+	}
+	if attrs.uid != -1 || attrs.gid != -1 {
+		fmt.Printf("  [chown]     %s (%s:%s)\n", dir, formatID(attrs.uid), formatID(attrs.gid))
+	}
+}
+
+// printSave prints where an overwritten file modified since installation is kept
+func printSave(f *stagedFile) {
+	if f.save != "" {
+		fmt.Printf("  [save]      %s -> %s (modified since installation)\n", f.target, f.save)
+	}
+}
+
+// printOwner prints the ownership change of a staged file. Ownership set by
+// the mapping takes precedence, otherwise fixOwnership decides.
+func printOwner(f *stagedFile) error {
+	if f.attrs.uid != -1 || f.attrs.gid != -1 {
+		fmt.Printf("  [chown]     %s (%s:%s)\n", f.target, formatID(f.attrs.uid), formatID(f.attrs.gid))
+		return nil
+	}
+	return printChown(f.target)
+}
+
+// printChown prints the ownership change fixOwnership would apply to path
+func printChown(path string) error {
+	uid, gid, ok, err := sudoOwnerOf(path)
+	if err != nil {
+		return err
+	}
+	if ok {
+		fmt.Printf("  [chown]     %s (%d:%d)\n", path, uid, gid)
+	}
+	return nil
+}
+
+// formatID formats a UID or GID, with "-" for one that is left unchanged
+func formatID(id int) string {
+	if id == -1 {
+		return "-"
+	}
+	return strconv.Itoa(id)
+}
+
+// shortSum abbreviates a hex digest for display
+func shortSum(sum string) string {
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	return sum
+}
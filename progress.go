@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// progressDelay hides the progress bar of transfers that finish quickly
+	progressDelay = 500 * time.Millisecond
+	// progressRedraw limits how often the progress bar is redrawn
+	progressRedraw = 100 * time.Millisecond
+	// progressInterval is how often a progress line is printed when stdout is not a terminal
+	progressInterval = 10 * time.Second
+	// progressBarWidth is the number of characters of the bar itself
+	progressBarWidth = 30
+)
+
+// progress reports the progress of a download or extraction, as a bar that
+// is redrawn in place on terminals and as periodic lines otherwise
+type progress struct {
+	label string
+	out   io.Writer
+	tty   bool
+	now   func() time.Time
+
+	total       int64 // 0 when unknown
+	current     int64
+	transferred int64 // bytes processed since start, for the rate
+	start       time.Time
+	last        time.Time
+	drawn       bool
+}
+
+// newProgress starts reporting progress on stdout
+func newProgress(label string, total int64) *progress {
+	now := time.Now()
+	return &progress{
+		label: label,
+		out:   os.Stdout,
+		tty:   isTerminal(os.Stdout),
+		now:   time.Now,
+		total: total,
+		start: now,
+		last:  now,
+	}
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// setTotal updates the expected size, e.g. once the Content-Length is known
+func (p *progress) setTotal(total int64) {
+	p.total = total
+}
+
+// reset sets the current position without counting towards the rate, e.g.
+// when a download is restarted or resumed
+func (p *progress) reset(current int64) {
+	p.current = current
+}
+
+// add records n processed bytes
+func (p *progress) add(n int64) {
+	p.current += n
+	p.transferred += n
+	p.update()
+}
+
+// Write records processed bytes so progress can be used with io.MultiWriter and io.TeeReader
+func (p *progress) Write(b []byte) (int, error) {
+	p.add(int64(len(b)))
+	return len(b), nil
+}
+
+// update redraws the bar or prints a progress line when it is due
+func (p *progress) update() {
+	now := p.now()
+	if p.tty {
+		if now.Sub(p.start) < progressDelay || (p.drawn && now.Sub(p.last) < progressRedraw) {
+			return
+		}
+		fmt.Fprintf(p.out, "\r\033[K  %s", p.bar(now))
+		p.drawn = true
+	} else {
+		if now.Sub(p.last) < progressInterval {
+			return
+		}
+		fmt.Fprintf(p.out, "  %s\n", p.line(now))
+	}
+	p.last = now
+}
+
+// clear removes the progress bar so other output can be printed
+func (p *progress) clear() {
+	if p.drawn {
+		fmt.Fprint(p.out, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+// bar renders the progress bar shown on terminals
+func (p *progress) bar(now time.Time) string {
+	var b strings.Builder
+	if p.total > 0 {
+		filled := int(float64(progressBarWidth) * p.fraction())
+		b.WriteString("[" + strings.Repeat("=", filled))
+		if filled < progressBarWidth {
+			b.WriteString(">" + strings.Repeat(" ", progressBarWidth-filled-1))
+		}
+		fmt.Fprintf(&b, "] %3.0f%%  %s / %s", p.fraction()*100, formatBytes(p.current), formatBytes(p.total))
+	} else {
+		fmt.Fprintf(&b, "%s %s", p.label, formatBytes(p.current))
+	}
+	if rate := p.rate(now); rate > 0 {
+		fmt.Fprintf(&b, "  %s/s", formatBytes(int64(rate)))
+		if eta, ok := p.eta(rate); ok {
+			fmt.Fprintf(&b, "  ETA %s", eta)
+		}
+	}
+	return b.String()
+}
+
+// line renders a progress line for logs
+func (p *progress) line(now time.Time) string {
+	var b strings.Builder
+	if p.total > 0 {
+		fmt.Fprintf(&b, "%s: %s / %s (%.0f%%)", p.label, formatBytes(p.current), formatBytes(p.total), p.fraction()*100)
+	} else {
+		fmt.Fprintf(&b, "%s: %s", p.label, formatBytes(p.current))
+	}
+	if rate := p.rate(now); rate > 0 {
+		fmt.Fprintf(&b, ", %s/s", formatBytes(int64(rate)))
+		if eta, ok := p.eta(rate); ok {
+			fmt.Fprintf(&b, ", ETA %s", eta)
+		}
+	}
+	return b.String()
+}
+
+// fraction returns how much of the total has been processed, capped at 1
+func (p *progress) fraction() float64 {
+	if p.total <= 0 {
+		return 0
+	}
+	return min(float64(p.current)/float64(p.total), 1)
+}
+
+// rate returns the average number of bytes processed per second
+func (p *progress) rate(now time.Time) float64 {
+	elapsed := now.Sub(p.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(p.transferred) / elapsed
+}
+
+// eta estimates the remaining time at the given rate
+func (p *progress) eta(rate float64) (time.Duration, bool) {
+	if p.total <= 0 || p.current >= p.total {
+		return 0, false
+	}
+	seconds := float64(p.total-p.current) / rate
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second), true
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[exp])
+}
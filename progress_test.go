@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testProgress returns a progress writing to a buffer with a controllable clock
+func testProgress(tty bool, total int64) (*progress, *bytes.Buffer, *time.Time) {
+	var out bytes.Buffer
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := &progress{label: "Downloading", out: &out, tty: tty, total: total, start: now, last: now}
+	p.now = func() time.Time { return now }
+	return p, &out, &now
+}
+
+func TestProgressLines(t *testing.T) {
+	p, out, now := testProgress(false, 4<<20)
+
+	p.add(1 << 20)
+	if out.Len() != 0 {
+		t.Fatalf("expected no output before the interval, got %q", out.String())
+	}
+
+	*now = now.Add(progressInterval)
+	p.add(1 << 20)
+	want := "  Downloading: 2.0 MiB / 4.0 MiB (50%), 204.8 KiB/s, ETA 10s\n"
+	if out.String() != want {
+		t.Errorf("unexpected progress line %q, want %q", out.String(), want)
+	}
+
+	p.clear()
+	if strings.Contains(out.String(), "\r") {
+		t.Error("expected no terminal control characters in plain output")
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	p, out, now := testProgress(true, 0)
+
+	p.add(1000)
+	if out.Len() != 0 {
+		t.Fatalf("expected no bar for a short transfer, got %q", out.String())
+	}
+
+	*now = now.Add(time.Second)
+	p.add(1000)
+	if want := "\r\033[K  Downloading 2.0 KiB  2.0 KiB/s"; out.String() != want {
+		t.Errorf("unexpected progress bar %q, want %q", out.String(), want)
+	}
+
+	// Redraws are rate limited
+	p.add(1000)
+	if strings.Count(out.String(), "\r") != 1 {
+		t.Errorf("expected a single redraw, got %q", out.String())
+	}
+
+	out.Reset()
+	p.clear()
+	if out.String() != "\r\033[K" {
+		t.Errorf("expected clear to erase the bar, got %q", out.String())
+	}
+}
+
+func TestProgressBarWithTotal(t *testing.T) {
+	p, _, now := testProgress(true, 1000)
+	p.current, p.transferred = 250, 250
+	*now = now.Add(time.Second)
+
+	want := "[=======>                      ]  25%  250 B / 1000 B  250 B/s  ETA 3s"
+	if got := p.bar(*now); got != want {
+		t.Errorf("bar() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %s, want %s", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Receipt records everything an installation wrote to the filesystem
+type Receipt struct {
+	Name          string            `json:"name"`
+	Version       string            `json:"version,omitempty"`
+	Source        string            `json:"source"`
+	ArchiveSHA256 string            `json:"archive_sha256,omitempty"`
+	InstalledAt   time.Time         `json:"installed_at"`
+	Files         []FileRecord      `json:"files"`
+	Directories   []DirectoryRecord `json:"directories"`
+}
+
+// FileRecord describes an installed file
+type FileRecord struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Mode   string `json:"mode"`
+	SHA256 string `json:"sha256,omitempty"`
+	Link   string `json:"link,omitempty"` // target of a symlink
+}
+
+// DirectoryRecord describes a directory created by an installation
+type DirectoryRecord struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+}
+
+// stateDir returns the directory where tgzetup keeps its state
+// Root uses /var/lib/tgzetup, other users use $XDG_STATE_HOME/tgzetup
+func stateDir() string {
+	if dir := os.Getenv("TGZETUP_STATE_DIR"); dir != "" {
+		return dir
+	}
+
+	if os.Geteuid() == 0 {
+		return "/var/lib/tgzetup"
+	}
+
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "tgzetup")
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "tgzetup")
+	}
+	return filepath.Join(homeDir, ".local", "state", "tgzetup")
+}
+
+// receiptPath returns the path of the receipt for the named package
+func receiptPath(name string) string {
+	return filepath.Join(stateDir(), "receipts", name+".json")
+}
+
+// LoadReceipt loads the receipt for the named package
+// The returned error wraps os.ErrNotExist when the package has no receipt
+func LoadReceipt(name string) (*Receipt, error) {
+	data, err := os.ReadFile(receiptPath(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no install receipt for %s: %w", name, err)
+		}
+		return nil, fmt.Errorf("failed to read install receipt: %w", err)
+	}
+
+	var receipt Receipt
+	if err := json.Unmarshal(data, &receipt); err != nil {
+		return nil, fmt.Errorf("failed to parse install receipt: %w", err)
+	}
+
+	return &receipt, nil
+}
+
+// ListReceipts loads the receipts of all installed packages, sorted by name
+func ListReceipts() ([]*Receipt, error) {
+	paths, err := filepath.Glob(filepath.Join(stateDir(), "receipts", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	receipts := []*Receipt{}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		receipt, err := LoadReceipt(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	sort.Slice(receipts, func(i, j int) bool {
+		return receipts[i].Name < receipts[j].Name
+	})
+	return receipts, nil
+}
+
+// Save writes the receipt to the state directory
+func (r *Receipt) Save() error {
+	path := receiptPath(r.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode install receipt: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated receipt
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write install receipt: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write install receipt: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveReceipt deletes the receipt for the named package
+func RemoveReceipt(name string) error {
+	if err := os.Remove(receiptPath(name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove install receipt: %w", err)
+	}
+	return nil
+}
+
+// addFile records a file installed at path, replacing any previous record for
+// the same path. Metadata is read from actual, which may be a staged copy.
+func (r *Receipt) addFile(path, actual string) error {
+	info, err := os.Lstat(actual)
+	if err != nil {
+		return err
+	}
+
+	record := FileRecord{
+		Path: path,
+		Size: info.Size(),
+		Mode: formatMode(info.Mode()),
+	}
+
+	// Symlinks are recorded by their target rather than content
+	if info.Mode()&os.ModeSymlink != 0 {
+		if record.Link, err = os.Readlink(actual); err != nil {
+			return err
+		}
+	} else if record.SHA256, err = fileSHA256(actual); err != nil {
+		return err
+	}
+
+	for i := range r.Files {
+		if r.Files[i].Path == path {
+			r.Files[i] = record
+			return nil
+		}
+	}
+	r.Files = append(r.Files, record)
+	return nil
+}
+
+// addDirectory records a directory created by the installation
+func (r *Receipt) addDirectory(path string, mode os.FileMode) {
+	if r.hasDirectory(path) {
+		return
+	}
+	r.Directories = append(r.Directories, DirectoryRecord{
+		Path: path,
+		Mode: formatMode(mode),
+	})
+}
+
+// hasPathWithin reports whether the receipt records a file or directory inside dir
+func (r *Receipt) hasPathWithin(dir string) bool {
+	for _, f := range r.Files {
+		if isPathWithinDir(f.Path, dir) && f.Path != dir {
+			return true
+		}
+	}
+	for _, d := range r.Directories {
+		if isPathWithinDir(d.Path, dir) && d.Path != dir {
+			return true
+		}
+	}
+	return false
+}
+
+// removeDirectory drops the record of a directory
+func (r *Receipt) removeDirectory(path string) {
+	for i, d := range r.Directories {
+		if d.Path == path {
+			r.Directories = append(r.Directories[:i], r.Directories[i+1:]...)
+			return
+		}
+	}
+}
+
+// removeFile drops the record of a file
+func (r *Receipt) removeFile(path string) {
+	for i, f := range r.Files {
+		if f.Path == path {
+			r.Files = append(r.Files[:i], r.Files[i+1:]...)
+			return
+		}
+	}
+}
+
+// findFile returns the record of the given file
+func (r *Receipt) findFile(path string) (FileRecord, bool) {
+	for _, f := range r.Files {
+		if f.Path == path {
+			return f, true
+		}
+	}
+	return FileRecord{}, false
+}
+
+// hasFile reports whether the receipt records the given file
+func (r *Receipt) hasFile(path string) bool {
+	for _, f := range r.Files {
+		if f.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDirectory reports whether the receipt records the given directory
+func (r *Receipt) hasDirectory(path string) bool {
+	for _, d := range r.Directories {
+		if d.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// mergePrevious carries over records from an earlier installation of the same
+// package that still exist on disk and were not rewritten by this installation
+func (r *Receipt) mergePrevious(prev *Receipt) {
+	for _, f := range prev.Files {
+		if r.hasFile(f.Path) {
+			continue
+		}
+		if _, err := os.Lstat(f.Path); err == nil {
+			r.Files = append(r.Files, f)
+		}
+	}
+
+	for _, d := range prev.Directories {
+		if r.hasDirectory(d.Path) {
+			continue
+		}
+		if info, err := os.Lstat(d.Path); err == nil && info.IsDir() {
+			r.Directories = append(r.Directories, d)
+		}
+	}
+}
+
+// sortedDirectories returns the recorded directories, deepest first
+func (r *Receipt) sortedDirectories() []DirectoryRecord {
+	dirs := make([]DirectoryRecord, len(r.Directories))
+	copy(dirs, r.Directories)
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return len(dirs[i].Path) > len(dirs[j].Path)
+	})
+	return dirs
+}
+
+// formatMode formats permission bits as an octal string such as "0755"
+func formatMode(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", uint32(mode.Perm()))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReceiptSaveLoad(t *testing.T) {
+	t.Setenv("TGZETUP_STATE_DIR", t.TempDir())
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "tool")
+	if err := os.WriteFile(file, []byte("foo"), 0755); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	receipt := &Receipt{Name: "tool", Source: "https://example.com/tool.tar.gz"}
+	if err := receipt.addFile(file, file); err != nil {
+		t.Fatalf("addFile() unexpected error: %v", err)
+	}
+	receipt.addDirectory(dir, 0755)
+	if err := receipt.Save(); err != nil {
+		t.Fatalf("Save() unexpected error: %v", err)
+	}
+
+	loaded, err := LoadReceipt("tool")
+	if err != nil {
+		t.Fatalf("LoadReceipt() unexpected error: %v", err)
+	}
+	if len(loaded.Files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(loaded.Files))
+	}
+	f := loaded.Files[0]
+	if f.Path != file || f.Size != 3 || f.Mode != "0755" {
+		t.Errorf("unexpected file record: %+v", f)
+	}
+	if f.SHA256 != "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae" {
+		t.Errorf("unexpected sha256: %s", f.SHA256)
+	}
+	if !loaded.hasDirectory(dir) {
+		t.Errorf("expected directory %s to be recorded", dir)
+	}
+
+	if err := RemoveReceipt("tool"); err != nil {
+		t.Fatalf("RemoveReceipt() unexpected error: %v", err)
+	}
+	if _, err := LoadReceipt("tool"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadReceipt() expected not exist error after removal, got %v", err)
+	}
+}
+
+func TestReceiptSortedDirectories(t *testing.T) {
+	receipt := &Receipt{}
+	receipt.addDirectory("/opt/tool", 0755)
+	receipt.addDirectory("/opt/tool/share/doc", 0755)
+	receipt.addDirectory("/opt/tool/share", 0755)
+
+	dirs := receipt.sortedDirectories()
+	want := []string{"/opt/tool/share/doc", "/opt/tool/share", "/opt/tool"}
+	for i, d := range dirs {
+		if d.Path != want[i] {
+			t.Errorf("sortedDirectories()[%d] = %s, want %s", i, d.Path, want[i])
+		}
+	}
+}
+
+func TestListReceipts(t *testing.T) {
+	t.Setenv("TGZETUP_STATE_DIR", t.TempDir())
+
+	receipts, err := ListReceipts()
+	if err != nil {
+		t.Fatalf("ListReceipts() unexpected error: %v", err)
+	}
+	if len(receipts) != 0 {
+		t.Fatalf("expected no receipts, got %d", len(receipts))
+	}
+
+	for _, name := range []string{"zoxide", "lima"} {
+		receipt := &Receipt{Name: name, Version: "1.0.0", Source: "https://example.com/" + name + ".tar.gz"}
+		if err := receipt.Save(); err != nil {
+			t.Fatalf("Save() unexpected error: %v", err)
+		}
+	}
+
+	receipts, err = ListReceipts()
+	if err != nil {
+		t.Fatalf("ListReceipts() unexpected error: %v", err)
+	}
+	if len(receipts) != 2 || receipts[0].Name != "lima" || receipts[1].Name != "zoxide" {
+		t.Fatalf("expected receipts for lima and zoxide, got %+v", receipts)
+	}
+	if receipts[0].Version != "1.0.0" {
+		t.Errorf("expected version 1.0.0, got %q", receipts[0].Version)
+	}
+}
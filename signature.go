@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Signature types
+const (
+	signatureMinisign = "minisign"
+	signatureSignify  = "signify"
+	signatureGPG      = "gpg"
+	signatureCosign   = "cosign"
+)
+
+// signatureSuffixes are appended to the archive URL when no signature URL is configured
+var signatureSuffixes = map[string]string{
+	signatureMinisign: ".minisig",
+	signatureSignify:  ".sig",
+	signatureGPG:      ".asc",
+	signatureCosign:   ".sig",
+}
+
+// Signature configures the detached signature the archive must carry
+type Signature struct {
+	Type          string `yaml:"type"`
+	URL           string `yaml:"url"`
+	PublicKey     string `yaml:"public_key"`
+	PublicKeyFile string `yaml:"public_key_file"`
+
+	key string // the public key, read from public_key_file if needed
+}
+
+// load validates the settings and reads the public key, resolving
+// public_key_file relative to the mapping file
+func (s *Signature) load(mappingPath string) error {
+	if _, ok := signatureSuffixes[s.Type]; !ok {
+		return fmt.Errorf("unsupported signature type %q, expected minisign, signify, gpg or cosign", s.Type)
+	}
+
+	switch {
+	case s.PublicKey != "" && s.PublicKeyFile != "":
+		return fmt.Errorf("'public_key' and 'public_key_file' cannot be used together")
+	case s.PublicKey != "":
+		s.key = s.PublicKey
+	case s.PublicKeyFile != "":
+		path := expandPath(s.PublicKeyFile)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(mappingPath), path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read public key: %w", err)
+		}
+		s.key = string(data)
+	default:
+		return fmt.Errorf("'public_key' or 'public_key_file' is required")
+	}
+
+	// Catch malformed keys before downloading anything, GPG keys are checked by gpg
+	switch s.Type {
+	case signatureMinisign, signatureSignify:
+		if _, err := parseEd25519PublicKey(s.key); err != nil {
+			return err
+		}
+	case signatureCosign:
+		if _, err := parseCosignPublicKey(s.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signatureURL returns where the signature of the archive is downloaded from
+// A relative URL is resolved against the archive URL, no URL at all means
+// the archive URL with the usual suffix of the signature type.
+func (s *Signature) signatureURL(archiveURL string) (string, error) {
+	if s.URL != "" {
+		return resolveChecksumURL(archiveURL, s.URL)
+	}
+	if archiveURL == stdinSource {
+		return "", fmt.Errorf("signature 'url' is required when reading the archive from standard input")
+	}
+	return archiveURL + signatureSuffixes[s.Type], nil
+}
+
+// VerifySignature downloads the detached signature of the archive and
+// verifies it with the configured public key. Any failure aborts the
+// installation.
+func VerifySignature(archivePath, archiveURL string, sig *Signature, opts DownloadOptions) error {
+	sigURL, err := sig.signatureURL(archiveURL)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Fetching %s signature from %s...\n", sig.Type, redactURL(sigURL))
+	data, err := fetchFile(sigURL, "signature file", opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Verifying archive signature...")
+	var signer string
+	switch sig.Type {
+	case signatureMinisign, signatureSignify:
+		signer, err = verifyEd25519Signature(archivePath, data, sig.key, sig.Type)
+	case signatureGPG:
+		signer, err = verifyGPGSignature(archivePath, data, sig.key)
+	case signatureCosign:
+		signer, err = verifyCosignSignature(archivePath, data, sig.key)
+	default:
+		err = fmt.Errorf("unsupported signature type %q", sig.Type)
+	}
+	if err != nil {
+		fmt.Printf("  [FAIL] %s signature\n", sig.Type)
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+
+	fmt.Printf("  [OK] %s signature by %s\n", sig.Type, signer)
+	return nil
+}
+
+// ed25519PublicKey is a minisign or signify public key
+type ed25519PublicKey struct {
+	keyID [8]byte
+	key   ed25519.PublicKey
+}
+
+// keyIDString formats a key ID the way minisign prints it
+func keyIDString(id [8]byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id[:]))
+}
+
+// base64Lines returns the lines of a minisign or signify file, skipping
+// empty lines. Untrusted comments are kept so their position is known.
+func base64Lines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// parseEd25519PublicKey parses a minisign or signify public key, either the
+// whole key file or just its base64 line
+func parseEd25519PublicKey(text string) (*ed25519PublicKey, error) {
+	var encoded string
+	for _, line := range base64Lines(text) {
+		if !strings.HasPrefix(line, "untrusted comment:") {
+			encoded = line
+			break
+		}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) != 2+8+ed25519.PublicKeySize || string(data[:2]) != "Ed" {
+		return nil, fmt.Errorf("invalid minisign/signify public key")
+	}
+
+	pk := &ed25519PublicKey{key: ed25519.PublicKey(data[10:])}
+	copy(pk.keyID[:], data[2:10])
+	return pk, nil
+}
+
+// verifyEd25519Signature verifies a minisign or signify signature file
+// minisign signatures may be made over the BLAKE2b-512 hash of the file
+// and carry a trusted comment, which is verified as well.
+func verifyEd25519Signature(archivePath string, sigFile []byte, keyText, sigType string) (string, error) {
+	pk, err := parseEd25519PublicKey(keyText)
+	if err != nil {
+		return "", err
+	}
+
+	lines := base64Lines(string(sigFile))
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], "untrusted comment:") {
+		return "", fmt.Errorf("invalid %s signature file", sigType)
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return "", fmt.Errorf("invalid %s signature", sigType)
+	}
+
+	algorithm := string(sig[:2])
+	var keyID [8]byte
+	copy(keyID[:], sig[2:10])
+	signature := sig[10:]
+
+	if keyID != pk.keyID {
+		return "", fmt.Errorf("signature was made with key %s, expected key %s", keyIDString(keyID), keyIDString(pk.keyID))
+	}
+
+	var message []byte
+	switch {
+	case algorithm == "Ed":
+		message, err = os.ReadFile(archivePath)
+	case algorithm == "ED" && sigType == signatureMinisign:
+		message, err = blake2bFile(archivePath)
+	default:
+		return "", fmt.Errorf("unsupported %s signature algorithm %q", sigType, algorithm)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	if !ed25519.Verify(pk.key, message, signature) {
+		return "", fmt.Errorf("invalid signature")
+	}
+
+	signer := "key " + keyIDString(pk.keyID)
+	if sigType == signatureSignify {
+		return signer, nil
+	}
+
+	// minisign also signs the trusted comment together with the signature
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return "", fmt.Errorf("minisign signature has no trusted comment")
+	}
+	comment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	globalSig, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return "", fmt.Errorf("invalid minisign global signature")
+	}
+	if !ed25519.Verify(pk.key, append(append([]byte{}, signature...), comment...), globalSig) {
+		return "", fmt.Errorf("invalid signature of the trusted comment")
+	}
+	return fmt.Sprintf("%s (trusted comment: %s)", signer, comment), nil
+}
+
+// blake2bFile returns the BLAKE2b-512 hash of a file
+func blake2bFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h, err := blake2b.New512(nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// parseCosignPublicKey parses a PEM encoded ECDSA or Ed25519 public key as
+// written by cosign generate-key-pair
+func parseCosignPublicKey(text string) (any, error) {
+	block, _ := pem.Decode([]byte(strings.TrimSpace(text)))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("invalid cosign public key: expected a PEM encoded PUBLIC KEY")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cosign public key: %w", err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported cosign public key type %T", key)
+	}
+}
+
+// verifyCosignSignature verifies a signature made with cosign sign-blob,
+// which is base64 encoded
+func verifyCosignSignature(archivePath string, sigFile []byte, keyText string) (string, error) {
+	key, err := parseCosignPublicKey(keyText)
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sigFile)))
+	if err != nil {
+		return "", fmt.Errorf("invalid cosign signature: %w", err)
+	}
+
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		f, err := os.Open(archivePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read archive: %w", err)
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", fmt.Errorf("failed to read archive: %w", err)
+		}
+		if !ecdsa.VerifyASN1(key, h.Sum(nil), sig) {
+			return "", fmt.Errorf("invalid signature")
+		}
+		return "ECDSA public key", nil
+	case ed25519.PublicKey:
+		message, err := os.ReadFile(archivePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read archive: %w", err)
+		}
+		if !ed25519.Verify(key, message, sig) {
+			return "", fmt.Errorf("invalid signature")
+		}
+		return "Ed25519 public key", nil
+	}
+	return "", fmt.Errorf("unsupported cosign public key type %T", key)
+}
+
+// verifyGPGSignature verifies a detached OpenPGP signature with gpg, using a
+// temporary keyring holding only the configured key so no other key is trusted
+func verifyGPGSignature(archivePath string, sigFile []byte, keyText string) (string, error) {
+	gpg, err := exec.LookPath("gpg")
+	if err != nil {
+		return "", fmt.Errorf("gpg is required to verify GPG signatures: %w", err)
+	}
+
+	home, err := os.MkdirTemp("", "tgzetup-gpg-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create keyring directory: %w", err)
+	}
+	defer os.RemoveAll(home)
+
+	importCmd := exec.Command(gpg, "--homedir", home, "--batch", "--no-tty", "--quiet", "--import")
+	importCmd.Stdin = strings.NewReader(keyText)
+	if output, err := importCmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to import public key: %s", strings.TrimSpace(string(output)))
+	}
+
+	sigPath := filepath.Join(home, "archive.sig")
+	if err := os.WriteFile(sigPath, sigFile, 0600); err != nil {
+		return "", fmt.Errorf("failed to write signature: %w", err)
+	}
+
+	// The status output is machine readable, a zero exit status alone is not relied upon
+	var status, stderr bytes.Buffer
+	verifyCmd := exec.Command(gpg, "--homedir", home, "--batch", "--no-tty", "--status-fd", "1", "--verify", sigPath, archivePath)
+	verifyCmd.Stdout = &status
+	verifyCmd.Stderr = &stderr
+	runErr := verifyCmd.Run()
+
+	fingerprint := ""
+	for _, line := range strings.Split(status.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "[GNUPG:]" {
+			continue
+		}
+		switch fields[1] {
+		case "BADSIG", "ERRSIG", "EXPSIG", "EXPKEYSIG", "REVKEYSIG":
+			return "", fmt.Errorf("gpg reported %s", strings.Join(fields[1:], " "))
+		case "VALIDSIG":
+			if len(fields) > 2 {
+				fingerprint = fields[2]
+			}
+		}
+	}
+	if runErr != nil || fingerprint == "" {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" && runErr != nil {
+			msg = runErr.Error()
+		}
+		return "", errors.New("gpg could not verify the signature: " + msg)
+	}
+	return "key " + fingerprint, nil
+}
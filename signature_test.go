@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// minisignKey is a throwaway minisign/signify key pair
+type minisignKey struct {
+	id   [8]byte
+	pub  ed25519.PublicKey
+	priv ed25519.PrivateKey
+}
+
+func newMinisignKey(t *testing.T) *minisignKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	k := &minisignKey{pub: pub, priv: priv}
+	rand.Read(k.id[:])
+	return k
+}
+
+func (k *minisignKey) publicKey() string {
+	data := append(append([]byte("Ed"), k.id[:]...), k.pub...)
+	return "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(data) + "\n"
+}
+
+// sign creates a signature file, prehashed with BLAKE2b-512 for algorithm "ED"
+func (k *minisignKey) sign(message []byte, algorithm string, trusted bool) string {
+	if algorithm == "ED" {
+		sum := blake2b.Sum512(message)
+		message = sum[:]
+	}
+	sig := ed25519.Sign(k.priv, message)
+	data := append(append([]byte(algorithm), k.id[:]...), sig...)
+	file := "untrusted comment: signature\n" + base64.StdEncoding.EncodeToString(data) + "\n"
+	if trusted {
+		const comment = "timestamp:1700000000\tfile:tool.tar.gz"
+		global := ed25519.Sign(k.priv, append(append([]byte{}, sig...), comment...))
+		file += "trusted comment: " + comment + "\n" + base64.StdEncoding.EncodeToString(global) + "\n"
+	}
+	return file
+}
+
+func writeArchive(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tool.tar.gz")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+	return path
+}
+
+// A prehashed signature of "test" made by minisign, from the go-minisign tests
+const (
+	minisignFixtureKey = "RWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y7GFO3"
+	minisignFixtureSig = "untrusted comment: signature from minisign secret key\n" +
+		"RUQf6LRCGA9i559r3g7V1qNyJDApGip8MfqcadIgT9CuhV3EMhHoN1mGTkUidF/z7SrlQgXdy8ofjb7bNJJylDOocrCo8KLzZwo=\n" +
+		"trusted comment: timestamp:1635443258\tfile:test\thashed\n" +
+		"/cj37GK60vryibFn+ftOgbCvW9NKhKYgjVpFFQUcWPAnjO23wrvVDTt7cloNC06maoBli9q6qwZDXXoaxweICQ==\n"
+)
+
+func TestVerifyMinisignFixture(t *testing.T) {
+	signer, err := verifyEd25519Signature(writeArchive(t, "test"), []byte(minisignFixtureSig), minisignFixtureKey, signatureMinisign)
+	if err != nil {
+		t.Fatalf("verifyEd25519Signature() unexpected error: %v", err)
+	}
+	if !strings.Contains(signer, "timestamp:1635443258") {
+		t.Errorf("expected the trusted comment in %q", signer)
+	}
+
+	if _, err := verifyEd25519Signature(writeArchive(t, "test2"), []byte(minisignFixtureSig), minisignFixtureKey, signatureMinisign); err == nil {
+		t.Error("verifyEd25519Signature() expected error for a modified archive, got nil")
+	}
+}
+
+func TestVerifyEd25519Signature(t *testing.T) {
+	key := newMinisignKey(t)
+	otherKey := newMinisignKey(t)
+	archive := writeArchive(t, "archive")
+	message := []byte("archive")
+
+	tampered := key.sign(message, "ED", true)
+	tamperedLines := strings.Split(tampered, "\n")
+	tamperedLines[2] = "trusted comment: forged"
+	tampered = strings.Join(tamperedLines, "\n")
+
+	tests := []struct {
+		name    string
+		sigType string
+		sig     string
+		key     string
+		wantErr bool
+	}{
+		{"minisign prehashed", signatureMinisign, key.sign(message, "ED", true), key.publicKey(), false},
+		{"minisign legacy", signatureMinisign, key.sign(message, "Ed", true), key.publicKey(), false},
+		{"signify", signatureSignify, key.sign(message, "Ed", false), key.publicKey(), false},
+		{"bare public key", signatureSignify, key.sign(message, "Ed", false), strings.Split(key.publicKey(), "\n")[1], false},
+		{"modified archive", signatureMinisign, key.sign([]byte("other"), "ED", true), key.publicKey(), true},
+		{"other key", signatureMinisign, otherKey.sign(message, "ED", true), key.publicKey(), true},
+		{"forged trusted comment", signatureMinisign, tampered, key.publicKey(), true},
+		{"missing trusted comment", signatureMinisign, key.sign(message, "ED", false), key.publicKey(), true},
+		{"signify prehashed", signatureSignify, key.sign(message, "ED", false), key.publicKey(), true},
+		{"garbage", signatureMinisign, "not a signature", key.publicKey(), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifyEd25519Signature(archive, []byte(tt.sig), tt.key, tt.sigType)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyEd25519Signature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyCosignSignature(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to encode key: %v", err)
+	}
+	pubPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	archive := writeArchive(t, "archive")
+	sign := func(message string) string {
+		digest := sha256.Sum256([]byte(message))
+		sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+
+	if _, err := verifyCosignSignature(archive, []byte(sign("archive")+"\n"), pubPEM); err != nil {
+		t.Errorf("verifyCosignSignature() unexpected error: %v", err)
+	}
+	if _, err := verifyCosignSignature(archive, []byte(sign("other")), pubPEM); err == nil {
+		t.Error("verifyCosignSignature() expected error for a modified archive, got nil")
+	}
+	if _, err := parseCosignPublicKey("not a key"); err == nil {
+		t.Error("parseCosignPublicKey() expected error for an invalid key, got nil")
+	}
+}
+
+func TestVerifyGPGSignature(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not available")
+	}
+
+	// Generate a throwaway key and sign the archive with it
+	home := t.TempDir()
+	gpg := func(args ...string) []byte {
+		t.Helper()
+		cmd := exec.Command("gpg", append([]string{"--homedir", home, "--batch", "--no-tty", "--passphrase", "", "--pinentry-mode", "loopback"}, args...)...)
+		output, err := cmd.Output()
+		if err != nil {
+			t.Skipf("gpg %s failed: %v", args[0], err)
+		}
+		return output
+	}
+	gpg("--quick-gen-key", "tgzetup test <test@example.com>", "ed25519", "sign", "never")
+	publicKey := string(gpg("--armor", "--export", "test@example.com"))
+
+	archive := writeArchive(t, "archive")
+	gpg("--armor", "--detach-sign", "--output", archive+".asc", archive)
+	sig, err := os.ReadFile(archive + ".asc")
+	if err != nil {
+		t.Fatalf("failed to read signature: %v", err)
+	}
+
+	if _, err := verifyGPGSignature(archive, sig, publicKey); err != nil {
+		t.Errorf("verifyGPGSignature() unexpected error: %v", err)
+	}
+
+	if err := os.WriteFile(archive, []byte("modified"), 0644); err != nil {
+		t.Fatalf("failed to modify archive: %v", err)
+	}
+	if _, err := verifyGPGSignature(archive, sig, publicKey); err == nil {
+		t.Error("verifyGPGSignature() expected error for a modified archive, got nil")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	key := newMinisignKey(t)
+	archive := writeArchive(t, "archive")
+	sig := &Signature{Type: signatureMinisign, key: key.publicKey()}
+
+	// Without a signature file verification fails closed
+	if err := VerifySignature(archive, archive, sig, testDownloadOptions); err == nil {
+		t.Fatal("VerifySignature() expected error for a missing signature, got nil")
+	}
+
+	// The signature is looked up next to the archive by default
+	if err := os.WriteFile(archive+".minisig", []byte(key.sign([]byte("archive"), "ED", true)), 0644); err != nil {
+		t.Fatalf("failed to write signature: %v", err)
+	}
+	if err := VerifySignature(archive, archive, sig, testDownloadOptions); err != nil {
+		t.Errorf("VerifySignature() unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stdinSource is the archive source that reads the archive from standard input
+const stdinSource = "-"
+
+// stdinLabel is recorded as the source of archives read from standard input
+const stdinLabel = "(stdin)"
+
+// localPath returns the file path of a local archive source, which is either
+// a file:// URL or a plain path. ok is false for remote URLs and standard input.
+func localPath(source string) (path string, ok bool, err error) {
+	if source == stdinSource {
+		return "", false, nil
+	}
+
+	if strings.HasPrefix(source, "file://") {
+		u, err := url.Parse(source)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid file URL: %w", err)
+		}
+		if u.Host != "" && u.Host != "localhost" {
+			return "", false, fmt.Errorf("file URL %s refers to a remote host %s", source, u.Host)
+		}
+		return u.Path, true, nil
+	}
+
+	if strings.Contains(source, "://") {
+		return "", false, nil
+	}
+	return source, true, nil
+}
+
+// normalizeSource returns the source as recorded in the receipt
+// Plain paths are made absolute so the receipt does not depend on the working directory.
+func normalizeSource(source string) (string, error) {
+	if source == stdinSource || strings.Contains(source, "://") {
+		return source, nil
+	}
+	absPath, err := filepath.Abs(source)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve archive path: %w", err)
+	}
+	return absPath, nil
+}
+
+// sourceLabel returns how the source is shown and recorded, without secrets
+func sourceLabel(source string) string {
+	if source == stdinSource {
+		return stdinLabel
+	}
+	return redactURL(source)
+}
+
+// FetchArchive copies the archive from source to destPath
+// source is an HTTP(S) URL, a file:// URL, a local path or "-" for standard input.
+func FetchArchive(source, destPath string, opts DownloadOptions) error {
+	if source == stdinSource {
+		fmt.Println("Reading archive from standard input...")
+		return copyArchive(os.Stdin, destPath, 0)
+	}
+
+	path, ok, err := localPath(source)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return DownloadArchive(source, destPath, opts)
+	}
+
+	fmt.Printf("Copying archive from %s...\n", path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("failed to open archive: %s is not a regular file", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	return copyArchive(file, destPath, info.Size())
+}
+
+// copyArchive writes the archive read from r to destPath, reporting
+// progress against size when it is known
+func copyArchive(r io.Reader, destPath string, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+
+	out, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create destination file: %w", err)
+	}
+	defer out.Close()
+
+	p := newProgress("Reading", size)
+	written, err := io.Copy(io.MultiWriter(out, p), r)
+	p.clear()
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	fmt.Printf("Read %d bytes\n", written)
+	return nil
+}
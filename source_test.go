@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		source  string
+		want    string
+		wantOK  bool
+		wantErr bool
+	}{
+		{"https://example.com/tool.tar.gz", "", false, false},
+		{"-", "", false, false},
+		{"dist/tool.tar.gz", "dist/tool.tar.gz", true, false},
+		{"/srv/dist/tool.tar.gz", "/srv/dist/tool.tar.gz", true, false},
+		{"file:///srv/dist/tool%201.0.tar.gz", "/srv/dist/tool 1.0.tar.gz", true, false},
+		{"file://localhost/srv/dist/tool.tar.gz", "/srv/dist/tool.tar.gz", true, false},
+		{"file://example.com/srv/dist/tool.tar.gz", "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			got, ok, err := localPath(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("localPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("localPath() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestFetchArchiveLocal(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "tool.tar.gz")
+	if err := os.WriteFile(archive, []byte("archive"), 0644); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+
+	for _, source := range []string{archive, "file://" + archive} {
+		destPath := filepath.Join(t.TempDir(), "archive")
+		if err := FetchArchive(source, destPath, testDownloadOptions); err != nil {
+			t.Fatalf("FetchArchive(%s) unexpected error: %v", source, err)
+		}
+		if data, _ := os.ReadFile(destPath); string(data) != "archive" {
+			t.Errorf("FetchArchive(%s) copied %q", source, data)
+		}
+	}
+
+	if err := FetchArchive(dir, filepath.Join(t.TempDir(), "archive"), testDownloadOptions); err == nil {
+		t.Error("FetchArchive() expected error for a directory, got nil")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StripComponents is the strip_components setting of a mapping file, either
+// a number of leading path components or "auto"
+type StripComponents struct {
+	Count int
+	Auto  bool // strip a single common top-level directory if there is one
+}
+
+// UnmarshalYAML accepts a non-negative integer or "auto"
+func (s *StripComponents) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode && value.Value == "auto" {
+		*s = StripComponents{Auto: true}
+		return nil
+	}
+
+	n, err := strconv.Atoi(value.Value)
+	if value.Kind != yaml.ScalarNode || err != nil || n < 0 {
+		return fmt.Errorf("line %d: strip_components must be a non-negative integer or \"auto\"", value.Line)
+	}
+	*s = StripComponents{Count: n}
+	return nil
+}
+
+// stripPath removes the first n components of an archive entry name
+// It reports false for entries that are removed entirely
+func stripPath(name string, n int) (string, bool) {
+	if n == 0 {
+		return name, true
+	}
+
+	parts := strings.Split(path.Clean(strings.TrimLeft(name, "/")), "/")
+	if len(parts) <= n {
+		return "", false
+	}
+	return strings.Join(parts[n:], "/"), true
+}
+
+// archiveEntry is the name and type of an entry, used to find the top-level directory
+type archiveEntry struct {
+	name  string
+	isDir bool
+}
+
+// resolveStrip returns the number of components to strip from the archive
+func resolveStrip(archivePath, format string, strip StripComponents) (int, error) {
+	if !strip.Auto {
+		return strip.Count, nil
+	}
+
+	entries, err := listEntries(archivePath, format)
+	if err != nil {
+		return 0, err
+	}
+
+	top := commonTopLevel(entries)
+	if top == "" {
+		fmt.Println("  No single top-level directory, nothing to strip")
+		return 0, nil
+	}
+	fmt.Printf("  Stripping top-level directory %s/\n", top)
+	return 1, nil
+}
+
+// commonTopLevel returns the directory every entry lives in, or an empty
+// string if the entries do not share a single top-level directory
+func commonTopLevel(entries []archiveEntry) string {
+	top := ""
+	for _, entry := range entries {
+		name := path.Clean(strings.TrimLeft(entry.name, "/"))
+		if name == "." {
+			continue
+		}
+
+		first, _, nested := strings.Cut(name, "/")
+		if top != "" && first != top {
+			return ""
+		}
+		// A file at the top level is not a directory that can be stripped
+		if !nested && !entry.isDir {
+			return ""
+		}
+		top = first
+	}
+	return top
+}
+
+// listEntries reads the names of all entries in the archive
+func listEntries(archivePath, format string) ([]archiveEntry, error) {
+	if format == formatZip {
+		zr, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zip archive: %w", err)
+		}
+		defer zr.Close()
+
+		var entries []archiveEntry
+		for _, f := range zr.File {
+			entries = append(entries, archiveEntry{name: f.Name, isDir: f.Mode().IsDir()})
+		}
+		return entries, nil
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	var entries []archiveEntry
+	err = readTar(file, format, func(r io.Reader) error {
+		tr := tar.NewReader(r)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read tar header: %w", err)
+			}
+			// Only entries that are extracted count, git archive starts with
+			// a pax_global_header entry that carries the commit ID
+			switch header.Typeflag {
+			case tar.TypeDir, tar.TypeReg, tar.TypeSymlink, tar.TypeLink:
+				entries = append(entries, archiveEntry{name: header.Name, isDir: header.Typeflag == tar.TypeDir})
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStripPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		strip  int
+		want   string
+		wantOK bool
+	}{
+		{"tool-1.0/bin/tool", 0, "tool-1.0/bin/tool", true},
+		{"tool-1.0/bin/tool", 1, "bin/tool", true},
+		{"./tool-1.0/bin/tool", 1, "bin/tool", true},
+		{"tool-1.0/bin/tool", 2, "tool", true},
+		{"tool-1.0/", 1, "", false},
+		{"tool-1.0/bin/tool", 3, "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := stripPath(tt.name, tt.strip)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("stripPath(%q, %d) = %q, %v, want %q, %v", tt.name, tt.strip, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestCommonTopLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		want    string
+	}{
+		{
+			name:    "single directory",
+			entries: []archiveEntry{{"tool-1.0/", true}, {"tool-1.0/bin/tool", false}},
+			want:    "tool-1.0",
+		},
+		{
+			name:    "implicit directory",
+			entries: []archiveEntry{{"./tool-1.0/bin/tool", false}, {"./tool-1.0/README", false}},
+			want:    "tool-1.0",
+		},
+		{
+			name:    "several top-level entries",
+			entries: []archiveEntry{{"bin/tool", false}, {"share/doc", false}},
+			want:    "",
+		},
+		{
+			name:    "top-level file",
+			entries: []archiveEntry{{"tool", false}},
+			want:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := commonTopLevel(tt.entries); got != tt.want {
+				t.Errorf("commonTopLevel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractArchiveStripComponents(t *testing.T) {
+	archivePath := writeTar(t, []*tar.Header{
+		{Name: "tool-1.0/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "tool-1.0/bin/tool", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "tool-1.0/bin/tool-link", Typeflag: tar.TypeLink, Linkname: "tool-1.0/bin/tool"},
+	}, map[string]string{"tool-1.0/bin/tool": "hello"})
+
+	for _, strip := range []StripComponents{{Count: 1}, {Auto: true}} {
+		destDir := t.TempDir()
+		if err := ExtractArchive(archivePath, destDir, formatTar, ExtractOptions{StripComponents: strip}); err != nil {
+			t.Fatalf("ExtractArchive(%+v) unexpected error: %v", strip, err)
+		}
+
+		for _, name := range []string{"tool", "tool-link"} {
+			data, err := os.ReadFile(filepath.Join(destDir, "bin", name))
+			if err != nil {
+				t.Fatalf("expected stripped file bin/%s: %v", name, err)
+			}
+			if string(data) != "hello" {
+				t.Errorf("unexpected content %q", data)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(destDir, "tool-1.0")); !os.IsNotExist(err) {
+			t.Errorf("expected top-level directory to be stripped, got %v", err)
+		}
+	}
+}
+
+func TestResolveStripGlobalHeader(t *testing.T) {
+	// Archives made by git archive and GitHub start with a pax global header
+	archivePath := writeTar(t, []*tar.Header{
+		{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "0123456789abcdef"}},
+		{Name: "tool-1.0/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "tool-1.0/bin/tool", Typeflag: tar.TypeReg, Mode: 0755},
+	}, map[string]string{"tool-1.0/bin/tool": "hello"})
+
+	got, err := resolveStrip(archivePath, formatTar, StripComponents{Auto: true})
+	if err != nil {
+		t.Fatalf("resolveStrip() unexpected error: %v", err)
+	}
+	if got != 1 {
+		t.Errorf("resolveStrip() = %d, want 1", got)
+	}
+}
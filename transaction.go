@@ -0,0 +1,431 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// stagedFile is a file written next to its destination, waiting to be moved into place
+type stagedFile struct {
+	target  string
+	staging string
+	attrs   fileAttrs // mode and ownership set by the mapping, shown in the dry-run plan
+	backup  string    // set once a pre-existing target has been moved aside
+	save    string    // where a replaced or removed file is kept after cleanup, for -save-modified
+}
+
+// dirAttrs are the mode and ownership a mapping sets on a directory it installs
+type dirAttrs struct {
+	path  string
+	attrs fileAttrs
+	prev  os.FileInfo // set for directories that existed before, restored on rollback
+}
+
+// transaction stages every installed file next to its destination and only
+// moves them into place once all mappings have been staged successfully.
+// Anything done so far can be undone with rollback.
+//
+// In dry-run mode files are staged inside stageDir instead and directories
+// are only recorded, so the destination is never touched.
+type transaction struct {
+	receipt      *Receipt
+	links        *linkResolver
+	staged       []*stagedFile
+	committed    []*stagedFile
+	removals     []*stagedFile // files removed on commit, kept as backups until cleanup
+	staleDirs    []string      // directories removed after commit if they are empty
+	createdDirs  []string
+	existingDirs []string // directories of directory mappings that existed before
+	dirAttrs     []dirAttrs
+	dryRun       bool
+	stageDir     string
+}
+
+// newTransaction creates a transaction recording installed files in receipt
+func newTransaction(receipt *Receipt, links *linkResolver) *transaction {
+	return &transaction{receipt: receipt, links: links}
+}
+
+// newDryRunTransaction creates a transaction that stages files in stageDir
+// and never modifies the destination
+func newDryRunTransaction(receipt *Receipt, links *linkResolver, stageDir string) *transaction {
+	return &transaction{receipt: receipt, links: links, dryRun: true, stageDir: stageDir}
+}
+
+// stagingPath returns the path a target is staged at before being moved into place
+func stagingPath(target string) string {
+	return filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".tgzetup-new")
+}
+
+// backupPath returns the path a pre-existing target is moved to during commit
+func backupPath(target string) string {
+	return filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".tgzetup-old")
+}
+
+// stagingFor returns the path the target is staged at
+func (tx *transaction) stagingFor(target string) string {
+	if tx.dryRun {
+		return filepath.Join(tx.stageDir, fmt.Sprintf("%d-%s", len(tx.staged), filepath.Base(target)))
+	}
+	return stagingPath(target)
+}
+
+// hasCreatedDir reports whether the transaction created the directory
+func (tx *transaction) hasCreatedDir(path string) bool {
+	for _, dir := range tx.createdDirs {
+		if dir == path {
+			return true
+		}
+	}
+	return false
+}
+
+// useExistingDir records a directory of a directory mapping that already
+// exists, so the dry-run plan can show changes to its ownership
+func (tx *transaction) useExistingDir(path string) {
+	for _, dir := range tx.existingDirs {
+		if dir == path {
+			return
+		}
+	}
+	tx.existingDirs = append(tx.existingDirs, path)
+}
+
+// mkdirAll creates a directory and any missing parents, remembering the ones
+// it created so they can be removed again on rollback
+func (tx *transaction) mkdirAll(path string, mode os.FileMode) error {
+	if tx.hasCreatedDir(path) {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s exists and is not a directory", path)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	// Create the parent first
+	parent := filepath.Dir(path)
+	if parent != path {
+		if err := tx.mkdirAll(parent, 0755); err != nil {
+			return err
+		}
+	}
+
+	if !tx.dryRun {
+		if err := os.Mkdir(path, mode); err != nil {
+			return err
+		}
+	}
+	tx.createdDirs = append(tx.createdDirs, path)
+	return nil
+}
+
+// setDirAttrs applies attrs to a directory once its files are in place, so a
+// restrictive mode does not prevent staging files inside it. prev is the
+// state of a directory that already existed, nil for a created one.
+func (tx *transaction) setDirAttrs(path string, attrs fileAttrs, prev os.FileInfo) {
+	if _, ok := tx.attrsOfDir(path); ok {
+		return
+	}
+	tx.dirAttrs = append(tx.dirAttrs, dirAttrs{path: path, attrs: attrs, prev: prev})
+}
+
+// attrsOfDir returns the attributes set on a directory, if any
+func (tx *transaction) attrsOfDir(path string) (fileAttrs, bool) {
+	for _, d := range tx.dirAttrs {
+		if d.path == path {
+			return d.attrs, true
+		}
+	}
+	return noAttrs, false
+}
+
+// removeFile removes target when the transaction commits, e.g. a file that
+// an upgraded version no longer ships
+func (tx *transaction) removeFile(target string) {
+	tx.removals = append(tx.removals, &stagedFile{target: target})
+}
+
+// saveFile renames target to save when the transaction commits, e.g. a
+// modified file that an upgraded version no longer ships
+func (tx *transaction) saveFile(target, save string) {
+	tx.removals = append(tx.removals, &stagedFile{target: target, save: save})
+}
+
+// removeDirectory marks a directory for removal once it is empty
+func (tx *transaction) removeDirectory(path string) {
+	tx.staleDirs = append(tx.staleDirs, path)
+}
+
+// stageFile writes a file for target at its staging path using write
+func (tx *transaction) stageFile(target string, write func(staging string) error) error {
+	for _, f := range tx.staged {
+		if f.target == target {
+			return fmt.Errorf("%s is installed by more than one mapping", target)
+		}
+	}
+	if info, err := os.Lstat(target); err == nil && info.IsDir() {
+		return fmt.Errorf("%s exists and is a directory", target)
+	}
+
+	// Create destination directory if it doesn't exist
+	dir := filepath.Dir(target)
+	if err := tx.mkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// Fix ownership of parent directories if they were just created
+	if !tx.dryRun {
+		if err := fixOwnershipPath(dir); err != nil {
+			return err
+		}
+	}
+
+	// Remove leftovers of an interrupted run
+	staging := tx.stagingFor(target)
+	if err := os.Remove(staging); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Register before writing so a partially written file is cleaned up on rollback
+	tx.staged = append(tx.staged, &stagedFile{target: target, staging: staging, attrs: noAttrs})
+	if err := write(staging); err != nil {
+		return err
+	}
+
+	return tx.receipt.addFile(target, staging)
+}
+
+// unstage drops a staged file so commit leaves its target untouched
+func (tx *transaction) unstage(target string) error {
+	for i, f := range tx.staged {
+		if f.target != target {
+			continue
+		}
+		if err := os.Remove(f.staging); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		tx.staged = append(tx.staged[:i], tx.staged[i+1:]...)
+		tx.receipt.removeFile(target)
+		return nil
+	}
+	return nil
+}
+
+// applyAttrs fixes the ownership of a staged file when running with sudo and
+// applies the mode and ownership requested by the mapping. Symlinks have no
+// mode of their own. A dry run only records the attributes for the plan, so
+// it works without the privileges the installation needs.
+func (tx *transaction) applyAttrs(staging string, attrs fileAttrs) error {
+	if tx.dryRun {
+		for _, f := range tx.staged {
+			if f.staging == staging {
+				f.attrs = attrs
+			}
+		}
+		return nil
+	}
+
+	if err := fixOwnership(staging); err != nil {
+		return fmt.Errorf("failed to fix ownership: %w", err)
+	}
+	info, err := os.Lstat(staging)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return attrs.applyOwner(staging)
+	}
+	return attrs.applyFile(staging)
+}
+
+// commit moves every staged file into place, backing up files it replaces
+func (tx *transaction) commit() error {
+	for _, f := range tx.staged {
+		if _, err := os.Lstat(f.target); err == nil {
+			backup := backupPath(f.target)
+			if err := os.RemoveAll(backup); err != nil {
+				return fmt.Errorf("failed to remove stale backup %s: %w", backup, err)
+			}
+			if err := os.Rename(f.target, backup); err != nil {
+				return fmt.Errorf("failed to back up %s: %w", f.target, err)
+			}
+			f.backup = backup
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		// Track as committed as soon as the backup exists so rollback restores it
+		tx.committed = append(tx.committed, f)
+		if err := os.Rename(f.staging, f.target); err != nil {
+			return fmt.Errorf("failed to move %s into place: %w", f.target, err)
+		}
+	}
+
+	// Move removed files aside so they can be restored on rollback
+	for _, f := range tx.removals {
+		info, err := os.Lstat(f.target)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			continue
+		}
+
+		backup := backupPath(f.target)
+		if err := os.RemoveAll(backup); err != nil {
+			return fmt.Errorf("failed to remove stale backup %s: %w", backup, err)
+		}
+		if err := os.Rename(f.target, backup); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", f.target, err)
+		}
+		f.backup = backup
+	}
+
+	// Deepest directories first, a parent may become read-only
+	for i := len(tx.dirAttrs) - 1; i >= 0; i-- {
+		d := tx.dirAttrs[i]
+		if err := d.attrs.applyDir(d.path); err != nil {
+			return fmt.Errorf("%s: %w", d.path, err)
+		}
+	}
+
+	// Record every directory the installation created, including parents of
+	// installed files, so uninstall removes them again once they are empty.
+	// Directories that existed before are never recorded.
+	for _, dir := range tx.createdDirs {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		tx.receipt.addDirectory(dir, info.Mode())
+	}
+
+	return nil
+}
+
+// rollback undoes everything the transaction did, restoring backed up files
+// and removing the directories it created
+func (tx *transaction) rollback() {
+	// A dry run only staged files inside the temporary directory
+	if tx.dryRun {
+		return
+	}
+
+	fmt.Println("Rolling back installation...")
+
+	// Restore removed files
+	for i := len(tx.removals) - 1; i >= 0; i-- {
+		f := tx.removals[i]
+		if f.backup == "" {
+			continue
+		}
+		if err := os.Rename(f.backup, f.target); err != nil {
+			fmt.Printf("  Failed to restore %s from %s: %v\n", f.target, f.backup, err)
+			continue
+		}
+		fmt.Printf("  Restored %s\n", f.target)
+	}
+
+	// Restore committed files in reverse order
+	for i := len(tx.committed) - 1; i >= 0; i-- {
+		f := tx.committed[i]
+
+		// The staging file has not been moved yet if commit failed on it
+		_, err := os.Lstat(f.staging)
+		moved := os.IsNotExist(err)
+		if moved {
+			if err := os.Remove(f.target); err != nil && !os.IsNotExist(err) {
+				fmt.Printf("  Failed to remove %s: %v\n", f.target, err)
+				continue
+			}
+		}
+
+		if f.backup != "" {
+			if err := os.Rename(f.backup, f.target); err != nil {
+				fmt.Printf("  Failed to restore %s from %s: %v\n", f.target, f.backup, err)
+				continue
+			}
+			fmt.Printf("  Restored %s\n", f.target)
+		} else if moved {
+			fmt.Printf("  Removed %s\n", f.target)
+		}
+	}
+
+	// Restore the mode and ownership of existing directories
+	for _, d := range tx.dirAttrs {
+		if d.prev == nil {
+			continue
+		}
+		if err := restoreDirAttrs(d.path, d.prev); err != nil {
+			fmt.Printf("  Failed to restore attributes of %s: %v\n", d.path, err)
+		}
+	}
+
+	// Remove staging files that were never moved into place
+	for _, f := range tx.staged {
+		if err := os.Remove(f.staging); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("  Failed to remove %s: %v\n", f.staging, err)
+		}
+	}
+
+	// Remove created directories, deepest first
+	for i := len(tx.createdDirs) - 1; i >= 0; i-- {
+		dir := tx.createdDirs[i]
+		if err := os.Remove(dir); err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Printf("  Failed to remove %s: %v\n", dir, err)
+			}
+			continue
+		}
+		fmt.Printf("  Removed %s (directory)\n", dir)
+	}
+
+	tx.staged = nil
+	tx.committed = nil
+	tx.removals = nil
+	tx.createdDirs = nil
+	tx.dirAttrs = nil
+}
+
+// cleanup removes the backups of replaced and removed files after a successful
+// commit, or moves them to their save path
+func (tx *transaction) cleanup() {
+	for _, files := range [][]*stagedFile{tx.committed, tx.removals} {
+		for _, f := range files {
+			if f.backup == "" {
+				continue
+			}
+			if f.save != "" {
+				if err := os.Rename(f.backup, f.save); err != nil {
+					fmt.Printf("  Warning: failed to save %s as %s: %v\n", f.target, f.save, err)
+				}
+				continue
+			}
+			if err := os.Remove(f.backup); err != nil && !os.IsNotExist(err) {
+				fmt.Printf("  Warning: failed to remove backup %s: %v\n", f.backup, err)
+			}
+		}
+	}
+}
+
+// restoreDirAttrs sets the ownership and mode of a directory back to prev
+func restoreDirAttrs(path string, prev os.FileInfo) error {
+	if st, ok := prev.Sys().(*syscall.Stat_t); ok {
+		if err := os.Lchown(path, int(st.Uid), int(st.Gid)); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(path, prev.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+}
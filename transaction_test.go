@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeStaged(content string) func(string) error {
+	return func(staging string) error {
+		return os.WriteFile(staging, []byte(content), 0644)
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	if err := os.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	created := filepath.Join(dir, "new", "sub", "file")
+
+	receipt := &Receipt{}
+	tx := newTransaction(receipt, nil)
+	if err := tx.stageFile(existing, writeStaged("new")); err != nil {
+		t.Fatalf("stageFile() unexpected error: %v", err)
+	}
+	if err := tx.stageFile(created, writeStaged("created")); err != nil {
+		t.Fatalf("stageFile() unexpected error: %v", err)
+	}
+
+	// Nothing is replaced before commit
+	if data, _ := os.ReadFile(existing); string(data) != "old" {
+		t.Errorf("existing file modified before commit: %q", data)
+	}
+
+	if err := tx.commit(); err != nil {
+		t.Fatalf("commit() unexpected error: %v", err)
+	}
+	tx.cleanup()
+
+	if data, _ := os.ReadFile(existing); string(data) != "new" {
+		t.Errorf("expected existing file to be replaced, got %q", data)
+	}
+	if data, _ := os.ReadFile(created); string(data) != "created" {
+		t.Errorf("expected new file to be created, got %q", data)
+	}
+	if _, err := os.Stat(backupPath(existing)); !os.IsNotExist(err) {
+		t.Errorf("expected backup to be removed after cleanup")
+	}
+
+	// Created parent directories are recorded, pre-existing ones are not
+	for _, d := range []string{filepath.Join(dir, "new"), filepath.Join(dir, "new", "sub")} {
+		if !receipt.hasDirectory(d) {
+			t.Errorf("expected created directory %s to be recorded", d)
+		}
+	}
+	if receipt.hasDirectory(dir) {
+		t.Errorf("expected pre-existing directory %s not to be recorded", dir)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	if err := os.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	created := filepath.Join(dir, "new", "sub", "file")
+
+	tx := newTransaction(&Receipt{}, nil)
+	if err := tx.stageFile(existing, writeStaged("new")); err != nil {
+		t.Fatalf("stageFile() unexpected error: %v", err)
+	}
+	if err := tx.stageFile(created, writeStaged("created")); err != nil {
+		t.Fatalf("stageFile() unexpected error: %v", err)
+	}
+	if err := tx.commit(); err != nil {
+		t.Fatalf("commit() unexpected error: %v", err)
+	}
+
+	// Rolling back after commit restores the original state
+	tx.rollback()
+
+	if data, _ := os.ReadFile(existing); string(data) != "old" {
+		t.Errorf("expected existing file to be restored, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+		t.Errorf("expected created directories to be removed")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the original file to remain, got %d entries", len(entries))
+	}
+}
+
+func TestTransactionRemoveFile(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "stale")
+	if err := os.WriteFile(stale, []byte("old"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	// Rolling back restores a removed file
+	tx := newTransaction(&Receipt{}, nil)
+	tx.removeFile(stale)
+	if err := tx.commit(); err != nil {
+		t.Fatalf("commit() unexpected error: %v", err)
+	}
+	if _, err := os.Lstat(stale); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed on commit", stale)
+	}
+	tx.rollback()
+	if data, _ := os.ReadFile(stale); string(data) != "old" {
+		t.Fatalf("expected removed file to be restored, got %q", data)
+	}
+
+	// Cleaning up after commit removes it for good
+	tx = newTransaction(&Receipt{}, nil)
+	tx.removeFile(stale)
+	if err := tx.commit(); err != nil {
+		t.Fatalf("commit() unexpected error: %v", err)
+	}
+	tx.cleanup()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected removed file and its backup to be gone, got %d entries", len(entries))
+	}
+}
+
+func TestTransactionStageFailure(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "a", "file")
+
+	tx := newTransaction(&Receipt{}, nil)
+	if err := tx.stageFile(target, writeStaged("a")); err != nil {
+		t.Fatalf("stageFile() unexpected error: %v", err)
+	}
+	if err := tx.stageFile(target, writeStaged("b")); err == nil {
+		t.Fatal("stageFile() expected error for duplicate target, got nil")
+	}
+	tx.rollback()
+
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("expected staged files and created directories to be removed")
+	}
+}
+
+func TestTransactionDryRun(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "new", "file")
+
+	tx := newDryRunTransaction(&Receipt{}, nil, t.TempDir())
+	if err := tx.stageFile(target, writeStaged("content")); err != nil {
+		t.Fatalf("stageFile() unexpected error: %v", err)
+	}
+
+	if len(tx.createdDirs) != 1 || tx.createdDirs[0] != filepath.Join(dir, "new") {
+		t.Errorf("expected directory to be planned, got %v", tx.createdDirs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+		t.Errorf("dry run must not create directories in the destination")
+	}
+	if err := tx.printPlan(); err != nil {
+		t.Errorf("printPlan() unexpected error: %v", err)
+	}
+}
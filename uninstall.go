@@ -1,18 +1,241 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-// Uninstall removes files according to the mapping configuration
-func Uninstall(config *Config) error {
-	fmt.Println("Removing installation...")
+// UninstallOptions holds command line options that affect uninstallation
+type UninstallOptions struct {
+	DryRun bool
+	// Force removes files that were modified after installation
+	Force bool
+	// SaveModified renames modified files to <path>.tgzetup-save instead of keeping them in place
+	SaveModified bool
+}
+
+// saveSuffix is appended to modified files saved by -save-modified
+const saveSuffix = ".tgzetup-save"
+
+// uninstaller removes installed paths, or only reports what it would remove in dry-run mode
+type uninstaller struct {
+	opts    UninstallOptions
+	removed map[string]bool
+	kept    []string // modified files left in place
+	saved   []string // modified files renamed with saveSuffix
+}
+
+// Uninstall removes the files recorded in the install receipt of the named package
+// When no receipt exists, removal falls back to the mapping configuration if given
+func Uninstall(name string, config *Config, opts UninstallOptions) error {
+	if opts.DryRun {
+		fmt.Println("Uninstallation plan (dry run, nothing will be removed):")
+	} else {
+		fmt.Println("Removing installation...")
+	}
+
+	u := &uninstaller{opts: opts, removed: make(map[string]bool)}
+
+	receipt, err := LoadReceipt(name)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) || config == nil {
+			return err
+		}
+		fmt.Printf("  No install receipt for %s, falling back to mapping file\n", name)
+		return u.uninstallMappings(config)
+	}
+
+	return u.uninstallReceipt(receipt)
+}
+
+// uninstallReceipt removes the files and directories recorded in a receipt
+// The receipt itself is only removed once everything was removed successfully
+func (u *uninstaller) uninstallReceipt(receipt *Receipt) error {
+	failed := 0
+
+	for _, file := range receipt.Files {
+		if err := u.uninstallRecordedFile(file); err != nil {
+			failed++
+		}
+	}
+
+	// Remove directories deepest first so parents are empty by the time they are reached
+	for _, dir := range receipt.sortedDirectories() {
+		if err := u.uninstallRecordedDirectory(dir.Path); err != nil {
+			failed++
+		}
+	}
+
+	u.printPreserved()
+
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d path(s), install receipt kept", failed)
+	}
+
+	if u.opts.DryRun {
+		fmt.Printf("  [remove] %s (install receipt)\n", receiptPath(receipt.Name))
+		return nil
+	}
+	return RemoveReceipt(receipt.Name)
+}
+
+// uninstallRecordedFile removes a file recorded in a receipt, preserving it
+// when it was modified after installation unless -force is given
+func (u *uninstaller) uninstallRecordedFile(file FileRecord) error {
+	path := file.Path
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			u.skip(path, "not found")
+			return nil
+		}
+		fmt.Printf("  Error processing %s: %v\n", path, err)
+		return err
+	}
+
+	if info.IsDir() {
+		u.skip(path, "no longer a file")
+		return nil
+	}
+
+	modified, err := isModified(file, info)
+	if err != nil {
+		fmt.Printf("  Error processing %s: %v\n", path, err)
+		return err
+	}
+	if modified && !u.opts.Force {
+		return u.preserveFile(path)
+	}
+	if modified {
+		fmt.Printf("  Removing %s although it was modified since installation (-force)\n", path)
+	}
+
+	return u.uninstallFile(path)
+}
+
+// isModified reports whether an installed file no longer matches its record
+// Records without a hash, written by older versions, are never reported as modified.
+func isModified(file FileRecord, info os.FileInfo) (bool, error) {
+	if file.Link != "" {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return true, nil
+		}
+		target, err := os.Readlink(file.Path)
+		if err != nil {
+			return false, err
+		}
+		return target != file.Link, nil
+	}
+
+	if file.SHA256 == "" {
+		return false, nil
+	}
+	if !info.Mode().IsRegular() {
+		return true, nil
+	}
+	sum, err := fileSHA256(file.Path)
+	if err != nil {
+		return false, err
+	}
+	return sum != file.SHA256, nil
+}
+
+// preserveFile keeps a modified file, either in place or renamed with saveSuffix
+func (u *uninstaller) preserveFile(path string) error {
+	if !u.opts.SaveModified {
+		u.kept = append(u.kept, path)
+		u.skip(path, "modified since installation")
+		return nil
+	}
+
+	savePath := path + saveSuffix
+	u.saved = append(u.saved, savePath)
+	if u.opts.DryRun {
+		fmt.Printf("  [save]   %s -> %s (modified since installation)\n", path, savePath)
+		return nil
+	}
 
+	if err := os.Rename(path, savePath); err != nil {
+		fmt.Printf("  Failed to save %s: %v\n", path, err)
+		return err
+	}
+	fmt.Printf("  Saved %s as %s (modified since installation)\n", path, savePath)
+	return nil
+}
+
+// printPreserved summarizes the modified files that were not removed
+func (u *uninstaller) printPreserved() {
+	kept, saved := "Kept", "Saved"
+	if u.opts.DryRun {
+		kept, saved = "Would keep", "Would save"
+	}
+	if len(u.kept) > 0 {
+		fmt.Printf("%s %d modified file(s), use -force to discard or -save-modified to rename them:\n", kept, len(u.kept))
+		for _, path := range u.kept {
+			fmt.Printf("  %s\n", path)
+		}
+	}
+	if len(u.saved) > 0 {
+		fmt.Printf("%s %d modified file(s):\n", saved, len(u.saved))
+		for _, path := range u.saved {
+			fmt.Printf("  %s\n", path)
+		}
+	}
+}
+
+// uninstallRecordedDirectory removes a directory recorded in a receipt if it is empty
+func (u *uninstaller) uninstallRecordedDirectory(path string) error {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		fmt.Printf("  Error processing %s: %v\n", path, err)
+		return err
+	}
+
+	// The receipt proves tgzetup created the directory, so only system roots are off limits
+	if isProtectedDirectory(path) {
+		u.skip(path, "protected directory")
+		return nil
+	}
+
+	// Entries removed earlier in a dry run still exist on disk
+	for _, entry := range entries {
+		if !u.removed[filepath.Join(path, entry.Name())] {
+			u.skip(path, "not empty")
+			return nil
+		}
+	}
+
+	if u.opts.DryRun {
+		fmt.Printf("  [remove] %s (directory)\n", path)
+		u.removed[path] = true
+		return nil
+	}
+
+	if err := os.Remove(path); err != nil {
+		fmt.Printf("  Failed to remove %s: %v\n", path, err)
+		return err
+	}
+
+	fmt.Printf("  Removed %s (directory)\n", path)
+	return nil
+}
+
+// uninstallMappings removes files according to the mapping configuration
+// This is used for installations made before install receipts existed
+func (u *uninstaller) uninstallMappings(config *Config) error {
 	for _, mapping := range config.Mappings {
-		if err := uninstallPath(mapping.To); err != nil {
+		// Without a receipt it is unknown which files a glob installed
+		if isGlob(mapping.From) {
+			u.skip(expandPath(mapping.To), "glob mapping, no install receipt")
+			continue
+		}
+		if err := u.uninstallPath(mapping.To); err != nil {
 			fmt.Printf("  Error processing %s: %v\n", mapping.To, err)
 			// Continue with other files
 		}
@@ -22,7 +245,7 @@ func Uninstall(config *Config) error {
 }
 
 // uninstallPath removes a single path
-func uninstallPath(mappingPath string) error {
+func (u *uninstaller) uninstallPath(mappingPath string) error {
 	targetPath := expandPath(mappingPath)
 
 	// Check if the target exists
@@ -37,17 +260,23 @@ func uninstallPath(mappingPath string) error {
 
 	// Handle directories
 	if info.IsDir() {
-		return uninstallDirectory(targetPath)
+		return u.uninstallDirectory(targetPath)
 	}
 
 	// Handle files
-	return uninstallFile(targetPath)
+	return u.uninstallFile(targetPath)
 }
 
 // uninstallDirectory removes a directory if safe to do so
-func uninstallDirectory(path string) error {
+func (u *uninstaller) uninstallDirectory(path string) error {
 	if !canRemoveDirectory(path) {
-		fmt.Printf("  Skipped %s (protected directory)\n", path)
+		u.skip(path, "protected directory")
+		return nil
+	}
+
+	if u.opts.DryRun {
+		fmt.Printf("  [remove] %s (directory and contents)\n", path)
+		u.removed[path] = true
 		return nil
 	}
 
@@ -61,7 +290,13 @@ func uninstallDirectory(path string) error {
 }
 
 // uninstallFile removes a single file
-func uninstallFile(path string) error {
+func (u *uninstaller) uninstallFile(path string) error {
+	if u.opts.DryRun {
+		fmt.Printf("  [remove] %s\n", path)
+		u.removed[path] = true
+		return nil
+	}
+
 	if err := os.Remove(path); err != nil {
 		fmt.Printf("  Failed to remove %s: %v\n", path, err)
 		return err
@@ -71,8 +306,60 @@ func uninstallFile(path string) error {
 	return nil
 }
 
+// skip reports a path that is left in place
+func (u *uninstaller) skip(path, reason string) {
+	if u.opts.DryRun {
+		fmt.Printf("  [skip]   %s (%s)\n", path, reason)
+		return
+	}
+	fmt.Printf("  Skipped %s (%s)\n", path, reason)
+}
+
+// protectedDirectories are never removed, even when an install receipt
+// records them as created by tgzetup
+var protectedDirectories = []string{
+	"/", "/bin", "/boot", "/dev", "/etc", "/home", "/lib", "/lib64", "/media", "/mnt",
+	"/opt", "/proc", "/root", "/run", "/sbin", "/srv", "/sys", "/tmp",
+	"/usr", "/usr/bin", "/usr/lib", "/usr/sbin", "/usr/share",
+	"/usr/local", "/usr/local/bin", "/usr/local/lib", "/usr/local/sbin", "/usr/local/share",
+	"/var", "/var/lib", "/var/log",
+}
+
+// isProtectedDirectory reports whether path is a system root or a home directory
+// Symlinks are resolved so an alias of a protected directory is protected too.
+func isProtectedDirectory(path string) bool {
+	candidates := []string{filepath.Clean(path)}
+	if abs, err := filepath.Abs(path); err == nil {
+		candidates = append(candidates, abs)
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		candidates = append(candidates, resolved)
+	}
+
+	protected := append([]string{}, protectedDirectories...)
+	for _, home := range []string{getRealHomeDir(), os.Getenv("HOME")} {
+		if home != "" {
+			protected = append(protected, filepath.Clean(home))
+		}
+	}
+
+	for _, candidate := range candidates {
+		// Home directories of other users
+		if filepath.Dir(candidate) == "/home" {
+			return true
+		}
+		for _, dir := range protected {
+			if candidate == dir {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // canRemoveDirectory checks if a directory can be safely removed
 // Only directories under home directory (excluding home itself) are allowed to be removed
+// This applies to directories taken from the mapping file, which tgzetup may not have created
 func canRemoveDirectory(path string) bool {
 	homeDir := getRealHomeDir()
 	if homeDir == "" {
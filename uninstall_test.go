@@ -0,0 +1,200 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUninstallModifiedFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      UninstallOptions
+		wantKept  bool
+		wantSaved bool
+	}{
+		{"kept by default", UninstallOptions{}, true, false},
+		{"saved", UninstallOptions{SaveModified: true}, false, true},
+		{"removed with force", UninstallOptions{Force: true}, false, false},
+		{"dry run", UninstallOptions{DryRun: true, SaveModified: true}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TGZETUP_STATE_DIR", t.TempDir())
+			dir := t.TempDir()
+			unchanged := filepath.Join(dir, "tool")
+			modified := filepath.Join(dir, "tool.conf")
+
+			receipt := &Receipt{Name: "tool"}
+			for _, path := range []string{unchanged, modified} {
+				if err := os.WriteFile(path, []byte("foo"), 0644); err != nil {
+					t.Fatalf("failed to write test file: %v", err)
+				}
+				if err := receipt.addFile(path, path); err != nil {
+					t.Fatalf("addFile() unexpected error: %v", err)
+				}
+			}
+			if err := receipt.Save(); err != nil {
+				t.Fatalf("Save() unexpected error: %v", err)
+			}
+
+			// Edited by an administrator after installation
+			if err := os.WriteFile(modified, []byte("edited"), 0644); err != nil {
+				t.Fatalf("failed to modify test file: %v", err)
+			}
+
+			if err := Uninstall("tool", nil, tt.opts); err != nil {
+				t.Fatalf("Uninstall() unexpected error: %v", err)
+			}
+
+			if _, err := os.Stat(unchanged); !tt.opts.DryRun && !os.IsNotExist(err) {
+				t.Errorf("expected unchanged file to be removed, got %v", err)
+			}
+			if _, err := os.Stat(modified); (err == nil) != tt.wantKept {
+				t.Errorf("modified file kept = %v, want %v", err == nil, tt.wantKept)
+			}
+			data, err := os.ReadFile(modified + saveSuffix)
+			if (err == nil) != tt.wantSaved {
+				t.Errorf("modified file saved = %v, want %v", err == nil, tt.wantSaved)
+			}
+			if tt.wantSaved && string(data) != "edited" {
+				t.Errorf("unexpected saved content %q", data)
+			}
+		})
+	}
+}
+
+func TestIsModified(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "tool")
+	link := filepath.Join(dir, "link")
+	if err := os.WriteFile(file, []byte("foo"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := os.Symlink("tool", link); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	const sum = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	tests := []struct {
+		name   string
+		record FileRecord
+		want   bool
+	}{
+		{"same content", FileRecord{Path: file, SHA256: sum}, false},
+		{"different content", FileRecord{Path: file, SHA256: "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9"}, true},
+		{"no recorded hash", FileRecord{Path: file}, false},
+		{"same link", FileRecord{Path: link, Link: "tool"}, false},
+		{"different link", FileRecord{Path: link, Link: "other"}, true},
+		{"file replaced by link", FileRecord{Path: link, SHA256: sum}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := os.Lstat(tt.record.Path)
+			if err != nil {
+				t.Fatalf("failed to stat: %v", err)
+			}
+			got, err := isModified(tt.record, info)
+			if err != nil {
+				t.Fatalf("isModified() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("isModified() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUninstallRemovesCreatedParents(t *testing.T) {
+	t.Setenv("TGZETUP_STATE_DIR", t.TempDir())
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SUDO_USER", "")
+
+	// ~/.config existed before, ~/.config/tool/conf.d is created for the file
+	existing := filepath.Join(home, ".config")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	target := filepath.Join(existing, "tool", "conf.d", "tool.conf")
+
+	receipt := &Receipt{Name: "tool"}
+	tx := newTransaction(receipt, nil)
+	if err := tx.stageFile(target, writeStaged("conf")); err != nil {
+		t.Fatalf("stageFile() unexpected error: %v", err)
+	}
+	if err := tx.commit(); err != nil {
+		t.Fatalf("commit() unexpected error: %v", err)
+	}
+	tx.cleanup()
+	if err := receipt.Save(); err != nil {
+		t.Fatalf("Save() unexpected error: %v", err)
+	}
+
+	if err := Uninstall("tool", nil, UninstallOptions{}); err != nil {
+		t.Fatalf("Uninstall() unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(existing, "tool")); !os.IsNotExist(err) {
+		t.Errorf("expected created parent directories to be removed, got %v", err)
+	}
+	if _, err := os.Stat(existing); err != nil {
+		t.Errorf("expected pre-existing directory to be kept, got %v", err)
+	}
+}
+
+func TestIsProtectedDirectory(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SUDO_USER", "")
+
+	link := filepath.Join(t.TempDir(), "usr-link")
+	if err := os.Symlink("/usr", link); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/", true},
+		{"/usr/local", true},
+		{"/usr/local/", true},
+		{"/etc", true},
+		{"/opt", true},
+		{"/var", true},
+		{"/home/alice", true},
+		{home, true},
+		{link, true},
+		{"/opt/tool", false},
+		{"/usr/local/share/tool", false},
+		{filepath.Join(home, ".config", "tool"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isProtectedDirectory(tt.path); got != tt.want {
+			t.Errorf("isProtectedDirectory(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestUninstallRecordedDirectoryOutsideHome(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SUDO_USER", "")
+
+	// A directory like /opt/tool, created by the installation
+	dir := filepath.Join(t.TempDir(), "tool")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	u := &uninstaller{removed: make(map[string]bool)}
+	if err := u.uninstallRecordedDirectory(dir); err != nil {
+		t.Fatalf("uninstallRecordedDirectory() unexpected error: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected recorded directory outside the home directory to be removed, got %v", err)
+	}
+}
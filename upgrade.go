@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// staleFiles returns the files of a previous installation that the new
+// installation no longer provides
+func staleFiles(prev, next *Receipt) []FileRecord {
+	var stale []FileRecord
+	for _, f := range prev.Files {
+		if !next.hasFile(f.Path) {
+			stale = append(stale, f)
+		}
+	}
+	return stale
+}
+
+// staleDirectories returns the directories of a previous installation that
+// hold nothing of the new installation, deepest first
+func staleDirectories(prev, next *Receipt) []string {
+	var stale []string
+	for _, d := range prev.Directories {
+		if !next.hasDirectory(d.Path) && !next.hasPathWithin(d.Path) {
+			stale = append(stale, d.Path)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return len(stale[i]) > len(stale[j])
+	})
+	return stale
+}
+
+// stageUpgrade removes files and directories of the previous installation
+// that are not part of the new version when the transaction commits.
+// Files modified since installation are kept like on uninstall, unless
+// -force or -save-modified is given, whether the new version still ships
+// them or not. The returned uninstaller holds the preserved files for the
+// summary.
+func stageUpgrade(prev *Receipt, tx *transaction, opts UninstallOptions) (*uninstaller, error) {
+	u := &uninstaller{opts: opts, removed: make(map[string]bool)}
+	if err := u.stageModifiedUpdates(prev, tx); err != nil {
+		return nil, err
+	}
+
+	for _, f := range staleFiles(prev, tx.receipt) {
+		info, err := os.Lstat(f.Path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			continue
+		}
+
+		modified, err := isModified(f, info)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", f.Path, err)
+		}
+		switch {
+		case modified && opts.SaveModified:
+			savePath := f.Path + saveSuffix
+			tx.saveFile(f.Path, savePath)
+			u.saved = append(u.saved, savePath)
+			fmt.Printf("  Staged saving of %s as %s (not in new version, modified since installation)\n", f.Path, savePath)
+		case modified && !opts.Force:
+			u.kept = append(u.kept, f.Path)
+			fmt.Printf("  Skipped removal of %s (modified since installation)\n", f.Path)
+		case modified:
+			tx.removeFile(f.Path)
+			fmt.Printf("  Staged removal of %s although it was modified since installation (-force)\n", f.Path)
+		default:
+			tx.removeFile(f.Path)
+			fmt.Printf("  Staged removal of %s (not in new version)\n", f.Path)
+		}
+	}
+	for _, dir := range staleDirectories(prev, tx.receipt) {
+		tx.removeDirectory(dir)
+	}
+	return u, nil
+}
+
+// stageModifiedUpdates applies the policy for modified files to staged files
+// that replace a file of the previous installation. A kept file is not
+// replaced and keeps the record of the previous installation, so it is not
+// removed as stale either.
+func (u *uninstaller) stageModifiedUpdates(prev *Receipt, tx *transaction) error {
+	// Unstaging changes tx.staged
+	staged := append([]*stagedFile(nil), tx.staged...)
+	for _, f := range staged {
+		record, ok := prev.findFile(f.target)
+		if !ok {
+			continue
+		}
+		info, err := os.Lstat(f.target)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		modified, err := isModified(record, info)
+		if err != nil {
+			return fmt.Errorf("failed to check %s: %w", f.target, err)
+		}
+		switch {
+		case !modified:
+		case u.opts.SaveModified:
+			f.save = f.target + saveSuffix
+			u.saved = append(u.saved, f.save)
+			fmt.Printf("  Staged saving of %s as %s (modified since installation)\n", f.target, f.save)
+		case !u.opts.Force:
+			if err := tx.unstage(f.target); err != nil {
+				return err
+			}
+			// Still part of the package, recorded as installed before
+			tx.receipt.Files = append(tx.receipt.Files, record)
+			u.kept = append(u.kept, f.target)
+			fmt.Printf("  Skipped update of %s (modified since installation)\n", f.target)
+		default:
+			fmt.Printf("  Staged update of %s although it was modified since installation (-force)\n", f.target)
+		}
+	}
+	return nil
+}
+
+// pruneStaleDirectories removes directories of the previous installation that
+// became empty and drops them from the receipt. It reports whether the
+// receipt changed.
+func pruneStaleDirectories(tx *transaction) bool {
+	u := &uninstaller{removed: make(map[string]bool)}
+	changed := false
+	for _, dir := range tx.staleDirs {
+		u.uninstallRecordedDirectory(dir)
+		if _, err := os.Lstat(dir); os.IsNotExist(err) {
+			tx.receipt.removeDirectory(dir)
+			changed = true
+		}
+	}
+	return changed
+}
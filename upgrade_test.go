@@ -0,0 +1,272 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStaleFilesAndDirectories(t *testing.T) {
+	prev := &Receipt{
+		Files: []FileRecord{
+			{Path: "/opt/tool/bin/tool"},
+			{Path: "/opt/tool/bin/old"},
+			{Path: "/opt/tool/share/doc/old.md"},
+		},
+		Directories: []DirectoryRecord{
+			{Path: "/opt/tool"},
+			{Path: "/opt/tool/share"},
+			{Path: "/opt/tool/share/doc"},
+		},
+	}
+	next := &Receipt{
+		Files: []FileRecord{
+			{Path: "/opt/tool/bin/tool"},
+			{Path: "/opt/tool/bin/new"},
+		},
+	}
+
+	var stale []string
+	for _, f := range staleFiles(prev, next) {
+		stale = append(stale, f.Path)
+	}
+	if want := []string{"/opt/tool/bin/old", "/opt/tool/share/doc/old.md"}; !reflect.DeepEqual(stale, want) {
+		t.Errorf("staleFiles() = %v, want %v", stale, want)
+	}
+
+	// /opt/tool still holds files of the new version
+	if got, want := staleDirectories(prev, next), []string{"/opt/tool/share/doc", "/opt/tool/share"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("staleDirectories() = %v, want %v", got, want)
+	}
+}
+
+func TestStageUpgradeModifiedFiles(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         UninstallOptions
+		wantModified bool // modified file still in place
+		wantSaved    bool
+	}{
+		{name: "keep by default", wantModified: true},
+		{name: "save", opts: UninstallOptions{SaveModified: true}, wantSaved: true},
+		{name: "force", opts: UninstallOptions{Force: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			unchanged := filepath.Join(dir, "unchanged")
+			modified := filepath.Join(dir, "NEWS")
+
+			prev := &Receipt{Name: "tool"}
+			for _, path := range []string{unchanged, modified} {
+				if err := os.WriteFile(path, []byte("installed"), 0644); err != nil {
+					t.Fatalf("failed to write test file: %v", err)
+				}
+				if err := prev.addFile(path, path); err != nil {
+					t.Fatalf("addFile() unexpected error: %v", err)
+				}
+			}
+			if err := os.WriteFile(modified, []byte("edited by admin"), 0644); err != nil {
+				t.Fatalf("failed to modify test file: %v", err)
+			}
+
+			// The new version ships neither file
+			tx := newTransaction(&Receipt{Name: "tool"}, nil)
+			if _, err := stageUpgrade(prev, tx, tt.opts); err != nil {
+				t.Fatalf("stageUpgrade() unexpected error: %v", err)
+			}
+			if err := tx.commit(); err != nil {
+				t.Fatalf("commit() unexpected error: %v", err)
+			}
+			tx.cleanup()
+
+			if _, err := os.Lstat(unchanged); !os.IsNotExist(err) {
+				t.Errorf("expected unchanged file to be removed, got %v", err)
+			}
+			if _, err := os.Lstat(modified); (err == nil) != tt.wantModified {
+				t.Errorf("modified file exists = %v, want %v", err == nil, tt.wantModified)
+			}
+			data, err := os.ReadFile(modified + saveSuffix)
+			if (err == nil) != tt.wantSaved {
+				t.Errorf("saved file exists = %v, want %v", err == nil, tt.wantSaved)
+			}
+			if tt.wantSaved && string(data) != "edited by admin" {
+				t.Errorf("saved file content = %q, want the edited content", data)
+			}
+		})
+	}
+}
+
+func TestStageUpgradeModifiedUpdates(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      UninstallOptions
+		want      string // content of the file after the upgrade
+		wantKept  bool
+		wantSaved bool
+	}{
+		{name: "keep by default", want: "edited by admin", wantKept: true},
+		{name: "save", opts: UninstallOptions{SaveModified: true}, want: "v2", wantSaved: true},
+		{name: "force", opts: UninstallOptions{Force: true}, want: "v2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			unchanged := filepath.Join(dir, "tool")
+			modified := filepath.Join(dir, "tool.conf")
+
+			prev := &Receipt{Name: "tool"}
+			for _, path := range []string{unchanged, modified} {
+				if err := os.WriteFile(path, []byte("v1"), 0644); err != nil {
+					t.Fatalf("failed to write test file: %v", err)
+				}
+				if err := prev.addFile(path, path); err != nil {
+					t.Fatalf("addFile() unexpected error: %v", err)
+				}
+			}
+			if err := os.WriteFile(modified, []byte("edited by admin"), 0644); err != nil {
+				t.Fatalf("failed to modify test file: %v", err)
+			}
+
+			// The new version ships both files
+			tx := newTransaction(&Receipt{Name: "tool"}, nil)
+			for _, path := range []string{unchanged, modified} {
+				if err := tx.stageFile(path, writeStaged("v2")); err != nil {
+					t.Fatalf("stageFile() unexpected error: %v", err)
+				}
+			}
+			u, err := stageUpgrade(prev, tx, tt.opts)
+			if err != nil {
+				t.Fatalf("stageUpgrade() unexpected error: %v", err)
+			}
+			if err := tx.commit(); err != nil {
+				t.Fatalf("commit() unexpected error: %v", err)
+			}
+			tx.receipt.mergePrevious(prev)
+			tx.cleanup()
+
+			if data, err := os.ReadFile(unchanged); err != nil || string(data) != "v2" {
+				t.Errorf("expected unchanged file to be updated, got %q (%v)", data, err)
+			}
+			if data, err := os.ReadFile(modified); err != nil || string(data) != tt.want {
+				t.Errorf("modified file content = %q (%v), want %q", data, err, tt.want)
+			}
+			data, err := os.ReadFile(modified + saveSuffix)
+			if (err == nil) != tt.wantSaved {
+				t.Errorf("saved file exists = %v, want %v", err == nil, tt.wantSaved)
+			}
+			if tt.wantSaved && string(data) != "edited by admin" {
+				t.Errorf("saved file content = %q, want the edited content", data)
+			}
+			if (len(u.kept) == 1) != tt.wantKept || (len(u.saved) == 1) != tt.wantSaved {
+				t.Errorf("preserved kept = %v, saved = %v", u.kept, u.saved)
+			}
+
+			// A kept file stays recorded as installed, so it is still detected as modified
+			record, ok := tx.receipt.findFile(modified)
+			prevRecord, _ := prev.findFile(modified)
+			if !ok || (record == prevRecord) != tt.wantKept {
+				t.Errorf("receipt record = %+v, previous %+v", record, prevRecord)
+			}
+		})
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	t.Setenv("TGZETUP_STATE_DIR", t.TempDir())
+	t.Setenv("SUDO_USER", "")
+
+	dest := t.TempDir()
+	config := &Config{
+		Name: "tool",
+		Mappings: []Mapping{
+			{From: "bin/tool", To: filepath.Join(dest, "bin", "tool")},
+			{From: "share", To: filepath.Join(dest, "share", "tool")},
+		},
+	}
+	v1 := writeTarGz(t, map[string]string{
+		"bin/tool":          "tool v1",
+		"share/doc/NEWS":    "news",
+		"share/doc/README":  "readme v1",
+		"share/plugins/old": "old plugin",
+	})
+	// v2 drops a file and a directory
+	v2 := writeTarGz(t, map[string]string{
+		"bin/tool":         "tool v2",
+		"share/doc/README": "readme v2",
+	})
+
+	if err := Install(v1, config, InstallOptions{Version: "1.0"}); err != nil {
+		t.Fatalf("Install() unexpected error: %v", err)
+	}
+	installed, err := LoadReceipt("tool")
+	if err != nil {
+		t.Fatalf("LoadReceipt() unexpected error: %v", err)
+	}
+
+	news := filepath.Join(dest, "share", "tool", "doc", "NEWS")
+	plugins := filepath.Join(dest, "share", "tool", "plugins")
+	v1Files := map[string]string{
+		filepath.Join(dest, "bin", "tool"):                    "tool v1",
+		filepath.Join(dest, "share", "tool", "doc", "README"): "readme v1",
+		news:                          "news",
+		filepath.Join(plugins, "old"): "old plugin",
+	}
+	checkFiles := func(files map[string]string) {
+		t.Helper()
+		for path, want := range files {
+			if data, err := os.ReadFile(path); err != nil || string(data) != want {
+				t.Errorf("%s: expected %q, got %q (%v)", path, want, data, err)
+			}
+		}
+	}
+
+	// Saving the receipt fails after the files were moved into place
+	blocker := receiptPath("tool") + ".tmp"
+	if err := os.Mkdir(blocker, 0755); err != nil {
+		t.Fatalf("failed to create test directory: %v", err)
+	}
+	if err := Install(v2, config, InstallOptions{Version: "2.0", Upgrade: true}); err == nil {
+		t.Fatal("Install() expected error for a failing upgrade, got nil")
+	}
+	checkFiles(v1Files)
+	if receipt, err := LoadReceipt("tool"); err != nil || !reflect.DeepEqual(receipt, installed) {
+		t.Errorf("expected the receipt of 1.0 to be unchanged, got %+v (%v)", receipt, err)
+	}
+	if err := os.Remove(blocker); err != nil {
+		t.Fatalf("failed to remove test directory: %v", err)
+	}
+
+	if err := Install(v2, config, InstallOptions{Version: "2.0", Upgrade: true}); err != nil {
+		t.Fatalf("Install() unexpected error: %v", err)
+	}
+	checkFiles(map[string]string{
+		filepath.Join(dest, "bin", "tool"):                    "tool v2",
+		filepath.Join(dest, "share", "tool", "doc", "README"): "readme v2",
+	})
+	for _, path := range []string{news, plugins} {
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", path, err)
+		}
+	}
+
+	receipt, err := LoadReceipt("tool")
+	if err != nil {
+		t.Fatalf("LoadReceipt() unexpected error: %v", err)
+	}
+	if receipt.Version != "2.0" {
+		t.Errorf("expected version 2.0, got %q", receipt.Version)
+	}
+	if receipt.hasFile(news) || receipt.hasFile(filepath.Join(plugins, "old")) {
+		t.Errorf("expected removed files to be dropped from the receipt, got %v", receipt.Files)
+	}
+	if receipt.hasDirectory(plugins) {
+		t.Errorf("expected removed directory to be dropped from the receipt, got %v", receipt.Directories)
+	}
+	if !receipt.hasDirectory(filepath.Join(dest, "share", "tool", "doc")) {
+		t.Errorf("expected directory of the new version to stay recorded, got %v", receipt.Directories)
+	}
+}
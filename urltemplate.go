@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+// urlVars returns the variables available in url templates
+// OS and Arch use Go's names unless the mapping file defines an alias
+func urlVars(config *Config, version string) map[string]string {
+	vars := map[string]string{
+		"OS":   runtime.GOOS,
+		"Arch": runtime.GOARCH,
+	}
+	if alias, ok := config.OSAliases[runtime.GOOS]; ok {
+		vars["OS"] = alias
+	}
+	if alias, ok := config.ArchAliases[runtime.GOARCH]; ok {
+		vars["Arch"] = alias
+	}
+
+	// Left out when unset so templates using it fail instead of producing a broken URL
+	if version != "" {
+		vars["Version"] = version
+	}
+	return vars
+}
+
+// parseURLTemplate parses a url template, failing on unknown variables
+func parseURLTemplate(text string) (*template.Template, error) {
+	return template.New("url").Option("missingkey=error").Parse(text)
+}
+
+// expandURLTemplate fills in the variables of a url template
+func expandURLTemplate(text string, vars map[string]string) (string, error) {
+	tmpl, err := parseURLTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars); err != nil {
+		if _, ok := vars["Version"]; !ok && strings.Contains(text, ".Version") {
+			return "", fmt.Errorf("url template requires a version, use -version-of")
+		}
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// ResolveURL returns the archive URL to install, expanding the url template
+// of the mapping file unless an explicit URL is given. A templated
+// checksum_url, signature url and the from and to paths of the mappings are
+// expanded with the same variables.
+func (c *Config) ResolveURL(explicit, version string) (string, error) {
+	vars := urlVars(c, version)
+
+	archiveURL := explicit
+	if archiveURL == "" {
+		if c.URL == "" {
+			return "", fmt.Errorf("no URL given and the mapping file has no 'url' template")
+		}
+		var err error
+		archiveURL, err = expandURLTemplate(c.URL, vars)
+		if err != nil {
+			return "", fmt.Errorf("failed to expand url template: %w", err)
+		}
+	}
+
+	if c.ChecksumURL != "" {
+		sumsURL, err := expandURLTemplate(c.ChecksumURL, vars)
+		if err != nil {
+			return "", fmt.Errorf("failed to expand checksum_url template: %w", err)
+		}
+		c.ChecksumURL = sumsURL
+	}
+
+	if c.Signature != nil && c.Signature.URL != "" {
+		sigURL, err := expandURLTemplate(c.Signature.URL, vars)
+		if err != nil {
+			return "", fmt.Errorf("failed to expand signature url template: %w", err)
+		}
+		c.Signature.URL = sigURL
+	}
+
+	// Archives often name files after the platform, e.g. tool.Linux-x86_64.gz
+	for i := range c.Mappings {
+		from, err := expandURLTemplate(c.Mappings[i].From, vars)
+		if err != nil {
+			return "", fmt.Errorf("failed to expand mapping %d 'from' template: %w", i, err)
+		}
+		to, err := expandURLTemplate(c.Mappings[i].To, vars)
+		if err != nil {
+			return "", fmt.Errorf("failed to expand mapping %d 'to' template: %w", i, err)
+		}
+		c.Mappings[i].From, c.Mappings[i].To = from, to
+	}
+
+	return archiveURL, nil
+}
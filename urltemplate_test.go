@@ -0,0 +1,77 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestConfigResolveURL(t *testing.T) {
+	config := &Config{
+		URL:         "https://example.com/v{{.Version}}/tool-{{.Version}}-{{.OS}}-{{.Arch}}.tar.gz",
+		ChecksumURL: "https://example.com/v{{.Version}}/SHA256SUMS",
+		OSAliases:   map[string]string{runtime.GOOS: "TestOS"},
+		ArchAliases: map[string]string{runtime.GOARCH: "testarch"},
+		Mappings: []Mapping{
+			{From: "share/agent.{{.OS}}-{{.Arch}}.gz", To: "/usr/local/bin/agent.{{.OS}}-{{.Arch}}"},
+		},
+	}
+
+	got, err := config.ResolveURL("", "1.2.1")
+	if err != nil {
+		t.Fatalf("ResolveURL() unexpected error: %v", err)
+	}
+	if want := "https://example.com/v1.2.1/tool-1.2.1-TestOS-testarch.tar.gz"; got != want {
+		t.Errorf("ResolveURL() = %q, want %q", got, want)
+	}
+	if want := "https://example.com/v1.2.1/SHA256SUMS"; config.ChecksumURL != want {
+		t.Errorf("checksum_url = %q, want %q", config.ChecksumURL, want)
+	}
+	want := Mapping{From: "share/agent.TestOS-testarch.gz", To: "/usr/local/bin/agent.TestOS-testarch"}
+	if config.Mappings[0] != want {
+		t.Errorf("mapping = %+v, want %+v", config.Mappings[0], want)
+	}
+}
+
+func TestConfigResolveURLDefaults(t *testing.T) {
+	config := &Config{URL: "https://example.com/tool-{{.OS}}-{{.Arch}}.tar.gz"}
+
+	got, err := config.ResolveURL("", "")
+	if err != nil {
+		t.Fatalf("ResolveURL() unexpected error: %v", err)
+	}
+	if want := "https://example.com/tool-" + runtime.GOOS + "-" + runtime.GOARCH + ".tar.gz"; got != want {
+		t.Errorf("ResolveURL() = %q, want %q", got, want)
+	}
+}
+
+func TestConfigResolveURLErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"no template", Config{}},
+		{"missing version", Config{URL: "https://example.com/tool-{{.Version}}.tar.gz"}},
+		{"unknown variable", Config{URL: "https://example.com/tool-{{.Release}}.tar.gz"}},
+		{"missing version in mapping", Config{URL: "https://example.com/tool.tar.gz", Mappings: []Mapping{{From: "tool-{{.Version}}", To: "/usr/local/bin/tool"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.config.ResolveURL("", ""); err == nil {
+				t.Error("ResolveURL() expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestConfigResolveURLExplicit(t *testing.T) {
+	config := &Config{URL: "https://example.com/tool-{{.Version}}.tar.gz"}
+
+	got, err := config.ResolveURL("https://mirror.example.com/tool.tar.gz", "")
+	if err != nil {
+		t.Fatalf("ResolveURL() unexpected error: %v", err)
+	}
+	if got != "https://mirror.example.com/tool.tar.gz" {
+		t.Errorf("ResolveURL() = %q, want the explicit URL", got)
+	}
+}
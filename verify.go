@@ -12,6 +12,21 @@ func VerifyArchiveStructure(extractedDir string, config *Config) error {
 
 	allValid := true
 	for _, mapping := range config.Mappings {
+		// Globs must match at least one path
+		if isGlob(mapping.From) {
+			matches, err := globArchive(extractedDir, mapping.From)
+			if err != nil {
+				return fmt.Errorf("failed to match %s: %w", mapping.From, err)
+			}
+			if len(matches) == 0 {
+				fmt.Printf("  [FAIL] %s matched nothing\n", mapping.From)
+				allValid = false
+			} else {
+				fmt.Printf("  [OK] %s matched %d path(s)\n", mapping.From, len(matches))
+			}
+			continue
+		}
+
 		sourcePath := filepath.Join(extractedDir, mapping.From)
 
 		// Check if the source file/directory exists